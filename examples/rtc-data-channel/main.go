@@ -13,7 +13,8 @@ import (
 func exampleReceive() error {
 	// Construct a LP2Receiver to receive peer connections.
 	receiver, err := NewLP2Receiver(LP2PReceiverConfig{
-		Nickname: "Receiver",
+		Nickname:  "Receiver",
+		UserAgent: receiverUserAgent,
 	})
 	if err != nil {
 		return err
@@ -51,11 +52,13 @@ func exampleReceive() error {
 func exampleConnect() error {
 	// Construct a LP2PRequest to request a connection.
 	request, err := NewLP2PRequest(LP2PRequestConfig{
-		Nickname: "Requester",
+		Nickname:  "Requester",
+		UserAgent: requestUserAgent,
 	})
 	if err != nil {
 		return err
 	}
+	defer request.Close() // Stop looking for peers
 
 	// Start the request
 	conn, err := request.Start()
@@ -99,24 +102,31 @@ func exampleConnect() error {
 var notifyPresenter func()
 var notifyConsumer func()
 
-func main() {
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
-
-	// mock user interaction
-	DefaultUserAgent.IgnoreConsent = true
-	DefaultUserAgent.PSKOverride = []byte("1234")
-	DefaultUserAgent.Presenter = func(psk []byte) {
+// mock user interaction, the receiver presents the pin and the requester
+// enters it.
+var receiverUserAgent = &MockUserAgent{
+	IgnoreConsent: true,
+	PSKOverride:   []byte("1234"),
+	Presenter: func(psk []byte) {
 		log.Println("The presenting browser (receiver) shows a pin:")
 		log.Printf("Pin: %s (presented to user)\n", string(psk))
-	}
-	DefaultUserAgent.Consumer = func() ([]byte, error) {
+	},
+}
+
+var requestUserAgent = &MockUserAgent{
+	IgnoreConsent: true,
+	Consumer: func() ([]byte, error) {
 		log.Println("The consuming browser (requester) asks the user to enter the pin:")
 		psk := []byte("1234")
 		log.Printf("Pin: %s (entered by user)\n", string(psk))
 		return psk, nil
-	}
+	},
+}
+
+func main() {
+	go func() {
+		log.Println(http.ListenAndServe("localhost:6060", nil))
+	}()
 
 	// Track example end
 	donePresenter := make(chan struct{})
@@ -0,0 +1,41 @@
+// Package atomicfile writes files that are either fully written or not at
+// all, e.g., to persist stores and identities.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temporary file in the same directory and
+// renames it to path. A crash doesn't leave a truncated file behind.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Chmod(perm)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	// The data needs to be on disk before the rename is.
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,43 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	for _, data := range []string{"first", "second"} {
+		err := WriteFile(path, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != data {
+			t.Fatalf("wrong data: %s != %s", actual, data)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("wrong permissions: %s", info.Mode().Perm())
+	}
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected files: %v", entries)
+	}
+}
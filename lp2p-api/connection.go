@@ -1,6 +1,7 @@
 package lp2p
 
 import (
+	ua "github.com/backkem/go-lp2p/lp2p-api/internal/useragent"
 	"github.com/backkem/go-lp2p/openscreen-go/network"
 	"github.com/backkem/go-lp2p/web-api"
 )
@@ -12,6 +13,9 @@ type LP2PConnection struct {
 	// Nickname string
 
 	conn *ospc.Connection
+	// granted is set for accepted connections, the peer can open
+	// dedicated transports over it.
+	granted *ua.GrantedConnection
 
 	OnDataChannel        web.CallbackSetter[OnDataChannelEvent]
 	onDataChannelHandler *web.EventHandler[OnDataChannelEvent]
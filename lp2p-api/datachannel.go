@@ -2,29 +2,120 @@ package lp2p
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/backkem/go-lp2p/openscreen-go/network"
 )
 
+// ErrInvalidState is returned when the channel isn't in the right state
+// for an operation, e.g., sending before the channel is open.
+var ErrInvalidState = errors.New("InvalidStateError: data channel is not open")
+
+// ErrBufferFull is returned when sending a message would exceed
+// MaxBufferedAmount.
+var ErrBufferFull = errors.New("OperationError: data channel send buffer is full")
+
+// MaxBufferedAmount is the number of bytes that can be queued for sending
+// on a data channel.
+const MaxBufferedAmount = 16 * 1024 * 1024
+
 // Data channel supports simple message passing over WebTransport.
 type DataChannel struct {
-	dc *ospc.DataChannel
-
 	mu          sync.Mutex
-	cbOnOpen    func(e OnOpenEvent)
-	cbOnMessage func(e OnMessageEvent)
+	params      ospc.DataChannelParameters
+	dc          *ospc.DataChannel // Set once open
+	state       DataChannelState
+	openPending bool // The open event fired before OnOpen was set
+	// cancelOpen aborts the handshake of a connecting channel.
+	cancelOpen context.CancelFunc
+
+	// Send queue, drained by sendLoop.
+	sendQueue                  []queuedMessage
+	sendNotify                 chan struct{}
+	sendDone                   chan struct{} // closed when sendLoop exits
+	bufferedAmount             uint64
+	bufferedAmountLowThreshold uint64
+
+	// Received messages not handed to OnMessage yet. deliverMu keeps
+	// them in order.
+	pendingMessages []OnMessageEvent
+	deliverMu       sync.Mutex
+
+	cbOnOpen              func(e OnOpenEvent)
+	cbOnMessage           func(e OnMessageEvent)
+	cbOnClosing           func(e OnClosingEvent)
+	cbOnClose             func(e OnCloseEvent)
+	cbOnError             func(e OnErrorEvent)
+	cbOnBufferedAmountLow func(e OnBufferedAmountLowEvent)
+}
+
+type queuedMessage struct {
+	data []byte
+	enc  ospc.DataEncoding
+}
+
+func newDataChannel(params ospc.DataChannelParameters, state DataChannelState) *DataChannel {
+	return &DataChannel{
+		mu:         sync.Mutex{},
+		params:     params,
+		state:      state,
+		sendNotify: make(chan struct{}, 1),
+		sendDone:   make(chan struct{}),
+	}
+}
+
+// DataChannelState is the ready state of a DataChannel.
+type DataChannelState int
+
+// DataChannelState enums
+const (
+	DataChannelStateConnecting DataChannelState = iota + 1
+	DataChannelStateOpen
+	DataChannelStateClosing
+	DataChannelStateClosed
+)
+
+func (s DataChannelState) String() string {
+	switch s {
+	case DataChannelStateConnecting:
+		return "connecting"
+	case DataChannelStateOpen:
+		return "open"
+	case DataChannelStateClosing:
+		return "closing"
+	case DataChannelStateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("Invalid DataChannelState (%d)", s)
+	}
 }
 
 // DataChannelInit can be used to configure properties of the underlying
 // channel.
 type DataChannelInit struct {
 	Protocol string
-	ID       uint64
+	// ID is assigned by the connection if not set.
+	ID *uint64
+	// Negotiated channels are created by both peers with the same ID
+	// instead of being announced, OnDataChannel doesn't fire for them.
+	Negotiated bool
+
+	// Ordered defaults to true. Unordered channels send each message on a
+	// QUIC stream of its own.
+	Ordered *bool
+	// MaxRetransmits and MaxPacketLifeTime, in milliseconds, make the
+	// channel partially reliable. At most one can be set and the channel
+	// must be unordered.
+	MaxRetransmits    *uint16
+	MaxPacketLifeTime *uint16
 }
 
-// CreateDataChannel creates a new data channel
+// CreateDataChannel creates a new data channel. The channel starts in the
+// connecting state and opens once the other peer accepted it, see OnOpen.
 func (c *LP2PConnection) CreateDataChannel(label string, opts *DataChannelInit) (*DataChannel, error) {
 	props := ospc.DataChannelParameters{
 		Label: label,
@@ -32,22 +123,61 @@ func (c *LP2PConnection) CreateDataChannel(label string, opts *DataChannelInit)
 	if opts != nil {
 		props.Protocol = opts.Protocol
 		props.ID = opts.ID
+		props.Negotiated = opts.Negotiated
+		if opts.Ordered != nil {
+			props.Unordered = !*opts.Ordered
+		}
+		if opts.MaxRetransmits != nil {
+			retransmits := uint64(*opts.MaxRetransmits)
+			props.MaxRetransmits = &retransmits
+		}
+		if opts.MaxPacketLifeTime != nil {
+			lifetime := time.Duration(*opts.MaxPacketLifeTime) * time.Millisecond
+			props.MaxPacketLifeTime = &lifetime
+		}
 	}
-	oDc, err := c.conn.OpenDataChannel(context.Background(), props)
+
+	err := props.Validate()
 	if err != nil {
 		return nil, err
 	}
 
-	dc := &DataChannel{
-		mu: sync.Mutex{},
-		dc: oDc,
-	}
+	dc := newDataChannel(props, DataChannelStateConnecting)
+	ctx, cancel := context.WithCancel(context.Background())
+	dc.cancelOpen = cancel
 
-	dc.run()
+	go dc.open(ctx, c.conn, props)
 
 	return dc, nil
 }
 
+// open runs the data-channel-open handshake. Closing the channel cancels
+// ctx.
+func (c *DataChannel) open(ctx context.Context, conn *ospc.Connection, props ospc.DataChannelParameters) {
+	oDc, err := conn.OpenDataChannel(ctx, props)
+	if err != nil {
+		// Closing a connecting channel is no error.
+		if ctx.Err() == nil {
+			c.onError(err)
+		}
+		c.setState(DataChannelStateClosed)
+		c.onClose()
+		return
+	}
+
+	c.mu.Lock()
+	c.dc = oDc
+	c.mu.Unlock()
+
+	// If the channel was closed while connecting, the send loop closes
+	// the stream right away.
+	if c.setState(DataChannelStateOpen) {
+		c.onOpen()
+	}
+
+	c.run()
+}
+
 func (c *LP2PConnection) run(transportListener *LP2PQuicTransportListener) {
 	// Listen for data channels.
 	go func() {
@@ -57,10 +187,10 @@ func (c *LP2PConnection) run(transportListener *LP2PQuicTransportListener) {
 				return
 			}
 
-			dc := &DataChannel{
-				mu: sync.Mutex{},
-				dc: oDc,
-			}
+			// The channel is open once accepted.
+			dc := newDataChannel(oDc.DataChannelParameters, DataChannelStateOpen)
+			dc.dc = oDc
+			dc.openPending = true
 
 			dc.run()
 
@@ -79,12 +209,29 @@ func (c *LP2PConnection) run(transportListener *LP2PQuicTransportListener) {
 			}
 
 			transportListener.handleTransport(incomingTransport{
-				Transport:   t,
+				Session:     newSession(t),
 				IsDedicated: false,
 			})
 		}
 	}()
 
+	// Listen for dedicated Transports
+	if c.granted == nil {
+		return
+	}
+	go func() {
+		for {
+			t, err := c.granted.AcceptTransport(context.Background())
+			if err != nil {
+				return
+			}
+
+			transportListener.handleTransport(incomingTransport{
+				Session:     newSession(t),
+				IsDedicated: true,
+			})
+		}
+	}()
 }
 
 // OnDataChannel fires when a data channel is opened.
@@ -93,10 +240,12 @@ type OnDataChannelEvent struct {
 }
 
 func (c *DataChannel) run() {
+	go c.sendLoop()
 	go func() {
 		for {
 			data, enc, err := c.dc.ReceiveMessageWithEncoding()
 			if err != nil {
+				c.teardown(err)
 				return
 			}
 
@@ -109,11 +258,17 @@ type OnMessageEvent struct {
 	Payload Payload
 }
 
+// OnMessage fires for each message received. Messages received before the
+// callback is set are kept until it is.
 func (c *DataChannel) OnMessage(callback func(e OnMessageEvent)) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	c.cbOnMessage = callback
+	pending := callback != nil && len(c.pendingMessages) > 0
+	c.mu.Unlock()
+
+	if pending {
+		go c.deliverMessages()
+	}
 }
 
 func (c *DataChannel) onMessage(data []byte, encoding ospc.DataEncoding) {
@@ -132,33 +287,191 @@ func (c *DataChannel) onMessage(data []byte, encoding ospc.DataEncoding) {
 		Payload: payload,
 	}
 	c.mu.Lock()
-	cb := c.cbOnMessage
+	c.pendingMessages = append(c.pendingMessages, e)
 	c.mu.Unlock()
 
-	cb(e)
+	c.deliverMessages()
+}
+
+// deliverMessages hands the pending messages to the OnMessage callback, if
+// set, in the order they were received.
+func (c *DataChannel) deliverMessages() {
+	c.deliverMu.Lock()
+	defer c.deliverMu.Unlock()
+
+	for {
+		c.mu.Lock()
+		cb := c.cbOnMessage
+		if cb == nil || len(c.pendingMessages) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		e := c.pendingMessages[0]
+		c.pendingMessages[0] = OnMessageEvent{}
+		c.pendingMessages = c.pendingMessages[1:]
+		c.mu.Unlock()
+
+		cb(e)
+	}
 }
 
-// Send a message to the other peer
+// Send queues a message to the other peer, it doesn't block. It returns
+// ErrInvalidState unless the channel is open and ErrBufferFull if the
+// message doesn't fit in the send queue, see BufferedAmount.
 func (c *DataChannel) Send(data []byte) error {
-	return c.dc.SendMessage(data)
+	return c.send(data, ospc.DataEncodingBinary)
 }
 
-// Send a message to the other peer
+// SendText queues a message to the other peer, see Send.
 func (c *DataChannel) SendText(data string) error {
-	return c.dc.SendMessageWithEncoding([]byte(data), ospc.DataEncodingString)
+	return c.send([]byte(data), ospc.DataEncodingString)
+}
+
+func (c *DataChannel) send(data []byte, enc ospc.DataEncoding) error {
+	c.mu.Lock()
+	if c.state != DataChannelStateOpen {
+		c.mu.Unlock()
+		return ErrInvalidState
+	}
+	if c.bufferedAmount+uint64(len(data)) > MaxBufferedAmount {
+		c.mu.Unlock()
+		return ErrBufferFull
+	}
+	c.sendQueue = append(c.sendQueue, queuedMessage{
+		data: append([]byte(nil), data...),
+		enc:  enc,
+	})
+	c.bufferedAmount += uint64(len(data))
+	c.mu.Unlock()
+
+	c.notifySend()
+	return nil
+}
+
+func (c *DataChannel) notifySend() {
+	select {
+	case c.sendNotify <- struct{}{}:
+	default:
+	}
+}
+
+// sendLoop writes the queued messages to the stream. Once the channel is
+// closing and the queue is drained, the stream is closed.
+func (c *DataChannel) sendLoop() {
+	defer close(c.sendDone)
+
+	for {
+		c.mu.Lock()
+		if len(c.sendQueue) == 0 {
+			closing := c.state >= DataChannelStateClosing
+			c.mu.Unlock()
+			if closing {
+				// Sends a FIN on the underlying stream.
+				_ = c.dc.Close()
+				return
+			}
+			<-c.sendNotify
+			continue
+		}
+		m := c.sendQueue[0]
+		c.sendQueue[0] = queuedMessage{}
+		c.sendQueue = c.sendQueue[1:]
+		c.mu.Unlock()
+
+		err := c.dc.SendMessageWithEncoding(m.data, m.enc)
+
+		c.mu.Lock()
+		if err != nil {
+			// The stream failed, the receive loop tears down the channel.
+			c.sendQueue = nil
+			c.bufferedAmount = 0
+			c.mu.Unlock()
+			return
+		}
+		threshold := c.bufferedAmountLowThreshold
+		crossed := c.bufferedAmount > threshold
+		c.bufferedAmount -= uint64(len(m.data))
+		crossed = crossed && c.bufferedAmount <= threshold
+		c.mu.Unlock()
+
+		if crossed {
+			c.onBufferedAmountLow()
+		}
+	}
+}
+
+// BufferedAmount returns the number of bytes queued for sending.
+func (c *DataChannel) BufferedAmount() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bufferedAmount
+}
+
+// BufferedAmountLowThreshold returns the threshold for OnBufferedAmountLow.
+func (c *DataChannel) BufferedAmountLowThreshold() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bufferedAmountLowThreshold
+}
+
+// SetBufferedAmountLowThreshold sets the threshold for
+// OnBufferedAmountLow. It defaults to 0.
+func (c *DataChannel) SetBufferedAmountLowThreshold(threshold uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bufferedAmountLowThreshold = threshold
+}
+
+type OnBufferedAmountLowEvent struct {
+	Channel *DataChannel
+}
+
+// OnBufferedAmountLow fires when sending brings BufferedAmount from above
+// to at or below BufferedAmountLowThreshold. It can be used to pace a
+// producer.
+func (c *DataChannel) OnBufferedAmountLow(callback func(e OnBufferedAmountLowEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cbOnBufferedAmountLow = callback
+}
+
+func (c *DataChannel) onBufferedAmountLow() {
+	e := OnBufferedAmountLowEvent{
+		Channel: c,
+	}
+	c.mu.Lock()
+	cb := c.cbOnBufferedAmountLow
+	c.mu.Unlock()
+
+	if cb != nil {
+		cb(e)
+	}
 }
 
 type OnOpenEvent struct {
 	Channel *DataChannel
 }
 
+// OnOpen fires when the channel is open. Since the channel may open
+// before the callback is set, a callback set late still gets the event.
 func (c *DataChannel) OnOpen(callback func(e OnOpenEvent)) {
 	c.mu.Lock()
 	c.cbOnOpen = callback
+	pending := c.openPending && callback != nil
+	if pending {
+		c.openPending = false
+	}
 	c.mu.Unlock()
 
-	// Just call right away for now.
-	c.onOpen()
+	if pending {
+		go callback(OnOpenEvent{
+			Channel: c,
+		})
+	}
 }
 
 func (c *DataChannel) onOpen() {
@@ -167,23 +480,196 @@ func (c *DataChannel) onOpen() {
 	}
 	c.mu.Lock()
 	cb := c.cbOnOpen
+	if cb == nil {
+		c.openPending = true
+	}
 	c.mu.Unlock()
 
-	cb(e)
+	if cb != nil {
+		cb(e)
+	}
 }
 
-// TODO: teardown
-// type OnCloseEvent struct {
-// }
-//
-// func (c *DataChannel) OnClose(callback func (e OnCloseEvent)) {
-//
-// }
+// ID returns the ID of the channel. It's nil while the connection didn't
+// assign one yet.
+func (c *DataChannel) ID() *uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dc != nil {
+		id := *c.dc.ID
+		return &id
+	}
+	if c.params.ID == nil {
+		return nil
+	}
+	id := *c.params.ID
+	return &id
+}
+
+// Negotiated returns if the channel was negotiated by the application.
+func (c *DataChannel) Negotiated() bool {
+	return c.params.Negotiated
+}
+
+// Ordered returns if messages are delivered in order.
+func (c *DataChannel) Ordered() bool {
+	return !c.params.Unordered
+}
+
+// MaxRetransmits returns the retransmission limit of a partially reliable
+// channel, if any.
+func (c *DataChannel) MaxRetransmits() *uint16 {
+	if c.params.MaxRetransmits == nil {
+		return nil
+	}
+	retransmits := uint16(*c.params.MaxRetransmits)
+	return &retransmits
+}
 
-// TODO: teardown
-// func (c *DataChannel) Close() error {
-//
-// }
+// MaxPacketLifeTime returns the lifetime in milliseconds of messages on a
+// partially reliable channel, if any.
+func (c *DataChannel) MaxPacketLifeTime() *uint16 {
+	if c.params.MaxPacketLifeTime == nil {
+		return nil
+	}
+	lifetime := uint16(c.params.MaxPacketLifeTime.Milliseconds())
+	return &lifetime
+}
+
+// ReadyState returns the state of the channel.
+func (c *DataChannel) ReadyState() DataChannelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// Close starts closing the channel. Messages that are already queued are
+// still delivered. The channel is closed once the other peer closed its
+// end as well, or right away if it's still connecting.
+func (c *DataChannel) Close() error {
+	if !c.setState(DataChannelStateClosing) {
+		return nil
+	}
+	c.onClosing()
+
+	// The send loop closes the stream once the queue is drained. If still
+	// connecting, the handshake is aborted, unless it completed already.
+	c.notifySend()
+	if c.cancelOpen != nil {
+		c.cancelOpen()
+	}
+	return nil
+}
+
+// teardown is called when the receive loop ends. The channel is closed
+// by the other peer on a clean EOF, other errors are reported first.
+func (c *DataChannel) teardown(err error) {
+	if !errors.Is(err, io.EOF) {
+		c.onError(err)
+	}
+
+	if c.setState(DataChannelStateClosing) {
+		c.onClosing()
+	}
+	c.notifySend()
+	<-c.sendDone
+
+	c.setState(DataChannelStateClosed)
+	c.onClose()
+}
+
+// setState moves the channel forward to the given state. It returns false
+// if the channel already reached the state.
+func (c *DataChannel) setState(state DataChannelState) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state >= state {
+		return false
+	}
+	c.state = state
+	return true
+}
+
+type OnClosingEvent struct {
+	Channel *DataChannel
+}
+
+// OnClosing fires when the channel starts closing, by either peer.
+func (c *DataChannel) OnClosing(callback func(e OnClosingEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cbOnClosing = callback
+}
+
+func (c *DataChannel) onClosing() {
+	e := OnClosingEvent{
+		Channel: c,
+	}
+	c.mu.Lock()
+	cb := c.cbOnClosing
+	c.mu.Unlock()
+
+	if cb != nil {
+		cb(e)
+	}
+}
+
+type OnCloseEvent struct {
+	Channel *DataChannel
+}
+
+// OnClose fires when the channel is closed.
+func (c *DataChannel) OnClose(callback func(e OnCloseEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cbOnClose = callback
+}
+
+func (c *DataChannel) onClose() {
+	e := OnCloseEvent{
+		Channel: c,
+	}
+	c.mu.Lock()
+	cb := c.cbOnClose
+	c.mu.Unlock()
+
+	if cb != nil {
+		cb(e)
+	}
+}
+
+type OnErrorEvent struct {
+	Channel *DataChannel
+	Err     error
+}
+
+// OnError fires when the channel fails, e.g., because the connection was
+// lost. The channel is closed afterwards.
+func (c *DataChannel) OnError(callback func(e OnErrorEvent)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cbOnError = callback
+}
+
+func (c *DataChannel) onError(err error) {
+	e := OnErrorEvent{
+		Channel: c,
+		Err:     err,
+	}
+	c.mu.Lock()
+	cb := c.cbOnError
+	c.mu.Unlock()
+
+	if cb != nil {
+		cb(e)
+	}
+}
 
 // PayloadType are the different types of data that can be
 // represented in a DataChannel message
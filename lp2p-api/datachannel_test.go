@@ -0,0 +1,336 @@
+package lp2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+// newLoopbackConnections returns a pair of connected LP2PConnections over
+// the loopback transport.
+func newLoopbackConnections(t *testing.T) (*LP2PConnection, *LP2PConnection) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listenAgent, err := ospc.NewAgent(ospc.NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ospc.NewListener(listenAgent, ospc.AgentTransportLoopback, nil)
+	l.WithDiscoveryProvider(ospc.NewMemoryDiscovery())
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	dialAgent, err := ospc.NewAgent(ospc.NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uConn, err := ospc.DialAddr(ctx, l.Addr().String(), string(listenAgent.PeerID), ospc.AgentTransportLoopback, dialAgent, ospc.WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lConn, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	psk := []byte("0124")
+	type result struct {
+		conn *ospc.Connection
+		err  error
+	}
+	lResult := make(chan result, 1)
+	go func() {
+		_, err := lConn.AcceptAuthenticate(ctx)
+		if err != nil {
+			lResult <- result{err: err}
+			return
+		}
+		conn, err := lConn.AuthenticatePSK(ctx, psk)
+		lResult <- result{conn: conn, err: err}
+	}()
+	dConn, err := uConn.AuthenticatePSK(ctx, psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-lResult
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	t.Cleanup(func() {
+		_ = dConn.Close()
+		_ = res.conn.Close()
+	})
+
+	dialer := newLP2PConnection(dConn)
+	dialer.run(nil)
+	listener := newLP2PConnection(res.conn)
+	listener.run(nil)
+
+	return dialer, listener
+}
+
+// wait returns the next value of ch or fails the test.
+func wait[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %s", what)
+	}
+	var v T
+	return v
+}
+
+// channelEvents records the lifecycle events of a data channel.
+type channelEvents struct {
+	open    chan struct{}
+	closing chan struct{}
+	close   chan struct{}
+	message chan OnMessageEvent
+}
+
+func watchChannel(dc *DataChannel) *channelEvents {
+	e := &channelEvents{
+		open:    make(chan struct{}, 1),
+		closing: make(chan struct{}, 1),
+		close:   make(chan struct{}, 1),
+		message: make(chan OnMessageEvent, 16),
+	}
+	dc.OnOpen(func(OnOpenEvent) { e.open <- struct{}{} })
+	dc.OnClosing(func(OnClosingEvent) { e.closing <- struct{}{} })
+	dc.OnClose(func(OnCloseEvent) { e.close <- struct{}{} })
+	dc.OnMessage(func(m OnMessageEvent) { e.message <- m })
+	return e
+}
+
+func TestDataChannelLifecycle(t *testing.T) {
+	dialer, listener := newLoopbackConnections(t)
+
+	accepted := make(chan *DataChannel, 1)
+	listener.OnDataChannel(func(e OnDataChannelEvent) {
+		accepted <- e.Channel
+	})
+
+	local, err := dialer.CreateDataChannel("chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localEvents := watchChannel(local)
+	wait(t, localEvents.open, "local open")
+	if local.ReadyState() != DataChannelStateOpen {
+		t.Fatalf("unexpected local state: %s", local.ReadyState())
+	}
+
+	remote := wait(t, accepted, "remote channel")
+	remoteEvents := watchChannel(remote)
+	wait(t, remoteEvents.open, "remote open")
+	if remote.ReadyState() != DataChannelStateOpen {
+		t.Fatalf("unexpected remote state: %s", remote.ReadyState())
+	}
+
+	err = local.SendText("ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := wait(t, remoteEvents.message, "message")
+	if p, ok := m.Payload.(PayloadString); !ok || string(p.Data) != "ping" {
+		t.Fatalf("unexpected payload: %#v", m.Payload)
+	}
+
+	// Closing one end closes both.
+	err = local.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.ReadyState() != DataChannelStateClosing {
+		t.Fatalf("unexpected local state: %s", local.ReadyState())
+	}
+	wait(t, localEvents.closing, "local closing")
+	wait(t, remoteEvents.closing, "remote closing")
+	wait(t, remoteEvents.close, "remote close")
+	wait(t, localEvents.close, "local close")
+	if local.ReadyState() != DataChannelStateClosed {
+		t.Fatalf("unexpected local state: %s", local.ReadyState())
+	}
+	if remote.ReadyState() != DataChannelStateClosed {
+		t.Fatalf("unexpected remote state: %s", remote.ReadyState())
+	}
+
+	err = local.SendText("late")
+	if err != ErrInvalidState {
+		t.Fatalf("expected ErrInvalidState, got: %v", err)
+	}
+}
+
+func TestDataChannelConnecting(t *testing.T) {
+	dialer, listener := newLoopbackConnections(t)
+
+	// The listening end of a negotiated channel is connecting until the
+	// dialing end creates it as well.
+	id := uint64(7)
+	init := &DataChannelInit{Negotiated: true, ID: &id}
+	remote, err := listener.CreateDataChannel("negotiated", init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteEvents := watchChannel(remote)
+	if remote.ReadyState() != DataChannelStateConnecting {
+		t.Fatalf("unexpected state: %s", remote.ReadyState())
+	}
+	err = remote.SendText("early")
+	if err != ErrInvalidState {
+		t.Fatalf("expected ErrInvalidState, got: %v", err)
+	}
+
+	local, err := dialer.CreateDataChannel("negotiated", init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localEvents := watchChannel(local)
+	wait(t, localEvents.open, "local open")
+	wait(t, remoteEvents.open, "remote open")
+
+	err = remote.SendText("pong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := wait(t, localEvents.message, "message")
+	if p, ok := m.Payload.(PayloadString); !ok || string(p.Data) != "pong" {
+		t.Fatalf("unexpected payload: %#v", m.Payload)
+	}
+}
+
+func TestDataChannelCloseConnecting(t *testing.T) {
+	_, listener := newLoopbackConnections(t)
+
+	// The dialing end never creates the negotiated channel.
+	id := uint64(11)
+	dc, err := listener.CreateDataChannel("negotiated", &DataChannelInit{Negotiated: true, ID: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := watchChannel(dc)
+	errs := make(chan error, 1)
+	dc.OnError(func(e OnErrorEvent) { errs <- e.Err })
+
+	err = dc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait(t, events.closing, "closing")
+	wait(t, events.close, "close")
+	if dc.ReadyState() != DataChannelStateClosed {
+		t.Fatalf("unexpected state: %s", dc.ReadyState())
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error: %v", err)
+	default:
+	}
+}
+
+func TestDataChannelEarlyMessages(t *testing.T) {
+	dialer, listener := newLoopbackConnections(t)
+
+	local, err := dialer.CreateDataChannel("chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan struct{}, 1)
+	local.OnOpen(func(OnOpenEvent) {
+		for _, m := range []string{"one", "two", "three"} {
+			_ = local.SendText(m)
+		}
+		opened <- struct{}{}
+	})
+	wait(t, opened, "local open")
+
+	// Messages that arrive before OnDataChannel and OnMessage are set
+	// are kept.
+	time.Sleep(100 * time.Millisecond)
+	accepted := make(chan *DataChannel, 1)
+	listener.OnDataChannel(func(e OnDataChannelEvent) {
+		accepted <- e.Channel
+	})
+	remote := wait(t, accepted, "remote channel")
+	time.Sleep(100 * time.Millisecond)
+	messages := make(chan OnMessageEvent, 3)
+	remote.OnMessage(func(e OnMessageEvent) {
+		messages <- e
+	})
+
+	for _, want := range []string{"one", "two", "three"} {
+		m := wait(t, messages, "message")
+		if p, ok := m.Payload.(PayloadString); !ok || string(p.Data) != want {
+			t.Fatalf("unexpected payload: %#v, want %s", m.Payload, want)
+		}
+	}
+}
+
+func TestDataChannelBufferedAmount(t *testing.T) {
+	dialer, listener := newLoopbackConnections(t)
+
+	id := uint64(9)
+	init := &DataChannelInit{Negotiated: true, ID: &id}
+	local, err := listener.CreateDataChannel("negotiated", init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.SetBufferedAmountLowThreshold(1024)
+	low := make(chan uint64, 1)
+	local.OnBufferedAmountLow(func(e OnBufferedAmountLowEvent) {
+		low <- e.Channel.BufferedAmount()
+	})
+
+	// Messages are queued while the open event is dispatched.
+	opened := make(chan error, 1)
+	local.OnOpen(func(OnOpenEvent) {
+		err := local.Send(make([]byte, MaxBufferedAmount))
+		if err != nil {
+			opened <- err
+			return
+		}
+		if local.BufferedAmount() != MaxBufferedAmount {
+			opened <- fmt.Errorf("unexpected buffered amount: %d", local.BufferedAmount())
+			return
+		}
+		opened <- local.Send([]byte{1})
+	})
+
+	remote, err := dialer.CreateDataChannel("negotiated", init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteEvents := watchChannel(remote)
+
+	err = wait(t, opened, "open")
+	if err != ErrBufferFull {
+		t.Fatalf("expected ErrBufferFull, got: %v", err)
+	}
+
+	// Draining the queue crosses the threshold.
+	amount := wait(t, low, "buffered amount low")
+	if amount > 1024 {
+		t.Fatalf("unexpected buffered amount: %d", amount)
+	}
+	m := wait(t, remoteEvents.message, "message")
+	if p, ok := m.Payload.(PayloadBinary); !ok || len(p.Data) != MaxBufferedAmount {
+		t.Fatalf("unexpected payload: %T", m.Payload)
+	}
+	select {
+	case <-low:
+		t.Fatal("unexpected buffered amount low event")
+	default:
+	}
+}
@@ -1,18 +1,123 @@
 package ua
 
-import "fmt"
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
 
-func NewCLIUserAgent() *mockUserAgent {
-	return &mockUserAgent{
-		Consumer:  CLICollector,
-		Presenter: CLIPresenter,
+// cliUserAgent interacts with the user on the command line.
+type cliUserAgent struct{}
+
+// NewCLIUserAgent creates a UserAgent that prompts on the command line.
+func NewCLIUserAgent() UserAgent {
+	return &cliUserAgent{}
+}
+
+func (a *cliUserAgent) ConsentListen(origin, nickname string) error {
+	fmt.Printf("Let %s accept connections as %s? (y/n):\n", originName(origin), nickname)
+	consent, err := readLine(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if consent != "y" {
+		return errConsentDenied
+	}
+	return nil
+}
+
+func (a *cliUserAgent) ConsentAccept(ctx context.Context, origin string, peer Peer) (Consent, error) {
+	fmt.Printf("Let %s accept the connection from %s? (y/n, a to always allow):\n", originName(origin), peer.Nickname)
+	consent, err := readLine(ctx)
+	if err != nil {
+		return ConsentDenied, err
+	}
+
+	switch consent {
+	case "y":
+		return ConsentOnce, nil
+	case "a":
+		return ConsentPermanent, nil
+	default:
+		return ConsentDenied, nil
+	}
+}
+
+func originName(origin string) string {
+	if origin == "" {
+		return "the app"
+	}
+	return origin
+}
+
+func (a *cliUserAgent) PresentPSK(psk []byte) {
+	CLIPresenter(psk)
+}
+
+func (a *cliUserAgent) CollectPSK(ctx context.Context) ([]byte, error) {
+	return CLICollector(ctx)
+}
+
+// SelectPeer renders the discovered peers as a numbered list, it's
+// rendered again when peers come and go.
+func (a *cliUserAgent) SelectPeer(ctx context.Context, peers *PeerList) (Peer, error) {
+	for {
+		changed := peers.Changed()
+		current := peers.Peers()
+		renderPeers(current)
+
+		select {
+		case <-ctx.Done():
+			return Peer{}, ctx.Err()
+		case <-changed:
+		case line, ok := <-stdin():
+			if !ok {
+				return Peer{}, errStdinClosed
+			}
+			i, err := strconv.Atoi(line)
+			if err != nil || i < 1 || i > len(current) {
+				fmt.Printf("Invalid peer: %s\n", line)
+				continue
+			}
+			return current[i-1], nil
+		}
+	}
+}
+
+func renderPeers(peers []Peer) {
+	if len(peers) == 0 {
+		fmt.Println("Looking for peers...")
+		return
 	}
+
+	fmt.Println("Peers:")
+	for i, p := range peers {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d) %s", i+1, p.Nickname)
+		if p.Model != "" {
+			fmt.Fprintf(&sb, " (%s)", p.Model)
+		}
+		if p.Trusted {
+			sb.WriteString(" [paired]")
+		}
+		fmt.Fprintf(&sb, " %s", p.ID)
+		fmt.Println(sb.String())
+	}
+	fmt.Printf("Select a peer (1-%d):\n", len(peers))
 }
 
-func CLICollector() ([]byte, error) {
-	pskEncoded := ""
+func CLICollector(ctx context.Context) ([]byte, error) {
 	fmt.Println("Enter pin:")
-	fmt.Scanln(&pskEncoded)
+	pskEncoded, err := readLine(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	psk, err := decodeNumeric(pskEncoded)
 	if err != nil {
@@ -26,3 +131,39 @@ func CLIPresenter(psk []byte) {
 	pskEncoded := encodeNumeric(psk)
 	fmt.Printf("Pin code: %s\n", pskEncoded)
 }
+
+var (
+	stdinOnce  sync.Once
+	stdinLines chan string
+)
+
+var errStdinClosed = errors.New("stdin closed")
+
+// stdin returns the lines read from stdin. All prompts share a single
+// reader, an abandoned prompt doesn't swallow the input of the next one.
+func stdin() <-chan string {
+	stdinOnce.Do(func() {
+		stdinLines = make(chan string)
+		go func() {
+			defer close(stdinLines)
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				stdinLines <- strings.TrimSpace(scanner.Text())
+			}
+		}()
+	})
+	return stdinLines
+}
+
+// readLine reads a line from stdin.
+func readLine(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case line, ok := <-stdin():
+		if !ok {
+			return "", errStdinClosed
+		}
+		return line, nil
+	}
+}
@@ -0,0 +1,75 @@
+package ua
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+// PeerFilter limits the discovered peers the user can pick from. A peer
+// matches the filter if it matches all fields that are set.
+type PeerFilter struct {
+	// NicknamePattern is matched against the nickname, see path.Match.
+	NicknamePattern string
+	// Model matches the advertised model name.
+	Model string
+	// PeerID matches a single peer.
+	PeerID ospc.PeerID
+	// Paired matches peers that were paired before or, if false, peers
+	// that weren't.
+	Paired *bool
+	// Capabilities must all be advertised by the peer.
+	Capabilities []string
+}
+
+// Validate checks the nickname pattern.
+func (f PeerFilter) Validate() error {
+	if f.NicknamePattern == "" {
+		return nil
+	}
+	_, err := path.Match(f.NicknamePattern, "")
+	if err != nil {
+		return fmt.Errorf("invalid nickname pattern %q: %w", f.NicknamePattern, err)
+	}
+	return nil
+}
+
+// Match determines if the peer matches the filter.
+func (f PeerFilter) Match(p Peer) bool {
+	if f.NicknamePattern != "" {
+		ok, err := path.Match(f.NicknamePattern, p.Nickname)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	if f.Model != "" && f.Model != p.Model {
+		return false
+	}
+	if f.PeerID != "" && f.PeerID != p.ID {
+		return false
+	}
+	if f.Paired != nil && *f.Paired != p.Trusted {
+		return false
+	}
+	for _, c := range f.Capabilities {
+		if !p.HasCapability(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPeer determines if the peer matches any of the filters. Without
+// filters all peers match.
+func matchPeer(filters []PeerFilter, p Peer) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f.Match(p) {
+			return true
+		}
+	}
+	return false
+}
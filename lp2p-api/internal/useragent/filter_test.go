@@ -0,0 +1,68 @@
+package ua
+
+import (
+	"testing"
+
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+func TestPeerFilterMatch(t *testing.T) {
+	paired := true
+	unpaired := false
+
+	peer := Peer{
+		ID:           ospc.PeerID("peer"),
+		Nickname:     "Living room TV",
+		Model:        "Kiosk",
+		Capabilities: []string{"video", "audio"},
+		Trusted:      true,
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter PeerFilter
+		match  bool
+	}{
+		{"empty", PeerFilter{}, true},
+		{"nickname", PeerFilter{NicknamePattern: "Living*"}, true},
+		{"other nickname", PeerFilter{NicknamePattern: "Kitchen*"}, false},
+		{"invalid nickname pattern", PeerFilter{NicknamePattern: "["}, false},
+		{"model", PeerFilter{Model: "Kiosk"}, true},
+		{"other model", PeerFilter{Model: "Phone"}, false},
+		{"peer ID", PeerFilter{PeerID: "peer"}, true},
+		{"other peer ID", PeerFilter{PeerID: "other"}, false},
+		{"paired", PeerFilter{Paired: &paired}, true},
+		{"unpaired", PeerFilter{Paired: &unpaired}, false},
+		{"capabilities", PeerFilter{Capabilities: []string{"audio", "video"}}, true},
+		{"missing capability", PeerFilter{Capabilities: []string{"video", "input"}}, false},
+		// All fields that are set need to match.
+		{"all", PeerFilter{NicknamePattern: "*TV", Model: "Kiosk", Paired: &paired}, true},
+		{"all but one", PeerFilter{NicknamePattern: "*TV", Model: "Kiosk", Paired: &unpaired}, false},
+	} {
+		if m := tc.filter.Match(peer); m != tc.match {
+			t.Errorf("%s: expected match %t, got %t", tc.name, tc.match, m)
+		}
+	}
+}
+
+func TestMatchPeer(t *testing.T) {
+	tv := Peer{ID: "tv", Nickname: "TV", Model: "Kiosk"}
+	phone := Peer{ID: "phone", Nickname: "Phone", Model: "Handheld"}
+
+	// Without filters all peers match.
+	if !matchPeer(nil, tv) || !matchPeer(nil, phone) {
+		t.Fatal("peer filtered without filters")
+	}
+
+	// A peer matches if any of the filters matches.
+	filters := []PeerFilter{
+		{Model: "Kiosk"},
+		{NicknamePattern: "Ph*"},
+	}
+	if !matchPeer(filters, tv) || !matchPeer(filters, phone) {
+		t.Fatal("peer matching one of the filters filtered")
+	}
+	if matchPeer(filters, Peer{ID: "laptop", Nickname: "Laptop"}) {
+		t.Fatal("peer matching none of the filters not filtered")
+	}
+}
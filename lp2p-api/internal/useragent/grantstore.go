@@ -0,0 +1,195 @@
+package ua
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/backkem/go-lp2p/internal/atomicfile"
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+// GrantStore records the permanent grants of peers to origins.
+type GrantStore interface {
+	// Get returns the grant of the peer to the origin.
+	Get(origin string, id ospc.PeerID) (OriginPeerGrant, bool)
+	// Add adds or replaces a grant.
+	Add(grant OriginPeerGrant) error
+	// Remove revokes the grant of the peer to the origin.
+	Remove(origin string, id ospc.PeerID) error
+	// RevokePeer revokes all grants of the peer.
+	RevokePeer(id ospc.PeerID) error
+	// List returns all grants.
+	List() []OriginPeerGrant
+}
+
+type grantKey struct {
+	origin string
+	id     ospc.PeerID
+}
+
+var _ GrantStore = (*MemoryGrantStore)(nil)
+
+// MemoryGrantStore is a GrantStore that is kept in memory only.
+type MemoryGrantStore struct {
+	mu     sync.Mutex
+	grants map[grantKey]OriginPeerGrant
+}
+
+// NewMemoryGrantStore creates an empty MemoryGrantStore.
+func NewMemoryGrantStore() *MemoryGrantStore {
+	return &MemoryGrantStore{
+		grants: make(map[grantKey]OriginPeerGrant),
+	}
+}
+
+func (s *MemoryGrantStore) Get(origin string, id ospc.PeerID) (OriginPeerGrant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[grantKey{origin, id}]
+	return grant, ok
+}
+
+func (s *MemoryGrantStore) Add(grant OriginPeerGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.grants[grantKey{grant.Origin, grant.ID}] = grant
+	return nil
+}
+
+func (s *MemoryGrantStore) Remove(origin string, id ospc.PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.grants, grantKey{origin, id})
+	return nil
+}
+
+func (s *MemoryGrantStore) RevokePeer(id ospc.PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.grants {
+		if k.id == id {
+			delete(s.grants, k)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryGrantStore) List() []OriginPeerGrant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants := make([]OriginPeerGrant, 0, len(s.grants))
+	for _, grant := range s.grants {
+		grants = append(grants, grant)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].Origin != grants[j].Origin {
+			return grants[i].Origin < grants[j].Origin
+		}
+		return grants[i].ID < grants[j].ID
+	})
+	return grants
+}
+
+var _ GrantStore = (*FileGrantStore)(nil)
+
+// FileGrantStore is a GrantStore that is persisted to a JSON file.
+// Every change is written to disk immediately.
+type FileGrantStore struct {
+	path string
+
+	mu    sync.Mutex
+	inner *MemoryGrantStore
+}
+
+// NewFileGrantStore opens the grant store at path. The file is created on
+// the first change if it doesn't exist yet.
+func NewFileGrantStore(path string) (*FileGrantStore, error) {
+	s := &FileGrantStore{
+		path:  path,
+		inner: NewMemoryGrantStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []OriginPeerGrant
+	err = json.Unmarshal(data, &grants)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode grant store %s: %w", path, err)
+	}
+	for _, grant := range grants {
+		s.inner.grants[grantKey{grant.Origin, grant.ID}] = grant
+	}
+
+	return s, nil
+}
+
+func (s *FileGrantStore) Get(origin string, id ospc.PeerID) (OriginPeerGrant, bool) {
+	return s.inner.Get(origin, id)
+}
+
+func (s *FileGrantStore) Add(grant OriginPeerGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.inner.Add(grant)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileGrantStore) Remove(origin string, id ospc.PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.inner.Remove(origin, id)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileGrantStore) RevokePeer(id ospc.PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.inner.RevokePeer(id)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileGrantStore) List() []OriginPeerGrant {
+	return s.inner.List()
+}
+
+// Caller should hold the lock.
+func (s *FileGrantStore) save() error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(s.inner.List())
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, buf.Bytes(), 0600)
+}
@@ -0,0 +1,70 @@
+package ua
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+func TestFileGrantStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grants.json")
+
+	s, err := NewFileGrantStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant := OriginPeerGrant{
+		ID:        ospc.PeerID("foo"),
+		Origin:    "https://example.com",
+		TrustedAt: time.Now().Round(0),
+		GrantedAt: time.Now().Round(0),
+		Permanent: true,
+	}
+	for _, g := range []OriginPeerGrant{
+		grant,
+		{ID: ospc.PeerID("foo"), Origin: "https://other.example.com", Permanent: true},
+		{ID: ospc.PeerID("bar"), Origin: "https://example.com", Permanent: true},
+		{ID: ospc.PeerID("baz"), Origin: "https://example.com", Permanent: true},
+	} {
+		err = s.Add(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.Remove("https://example.com", ospc.PeerID("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RevokePeer(ospc.PeerID("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileGrantStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, ok := reloaded.Get(grant.Origin, grant.ID)
+	if !ok {
+		t.Fatalf("grant not persisted")
+	}
+	if !actual.TrustedAt.Equal(grant.TrustedAt) || !actual.GrantedAt.Equal(grant.GrantedAt) || !actual.Permanent {
+		t.Fatalf("wrong grant: %+v != %+v", actual, grant)
+	}
+	if _, ok := reloaded.Get("https://other.example.com", grant.ID); !ok {
+		t.Fatalf("grant to other origin not persisted")
+	}
+	if _, ok := reloaded.Get("https://example.com", ospc.PeerID("bar")); ok {
+		t.Fatalf("removed grant persisted")
+	}
+	if _, ok := reloaded.Get("https://example.com", ospc.PeerID("baz")); ok {
+		t.Fatalf("revoked grant persisted")
+	}
+	if len(reloaded.List()) != 2 {
+		t.Fatalf("wrong number of grants: %d", len(reloaded.List()))
+	}
+}
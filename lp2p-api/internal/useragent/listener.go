@@ -2,24 +2,41 @@ package ua
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/backkem/go-lp2p/openscreen-go/network"
 )
 
+// ALPNWebTransport is the ALPN of WebTransport over a dedicated
+// QUIC connection.
+const ALPNWebTransport = "lp2p-webtransport"
+
+// TXT keys of the peer metadata advertised next to the OSP records.
+const (
+	txtKeyModel      = "mn"
+	txtKeyCapability = "cap"
+)
+
 type PeerListener struct {
-	m            *ConnectionManager
-	connListener *ospc.Listener
+	m                 *ConnectionManager
+	connListener      *ospc.Listener
+	transportListener *ospc.ALPNListener
+
+	// granted are the open connections granted to the origin. Dedicated
+	// transports are only accepted from their peers.
+	mu      sync.Mutex
+	granted map[ospc.PeerID]*GrantedConnection
 
 	close chan struct{}
 }
 
-// Listen starts the OSPC listener
-func (m *ConnectionManager) ListenConnection(nickname string) (*PeerListener, error) {
-	c := ospc.AgentConfig{
-		DisplayName: nickname,
-	}
-	a, err := ospc.NewAgent(c)
+// Listen starts the OSPC listener. The model and capabilities are
+// advertised, for requesting peers to filter on.
+func (m *ConnectionManager) ListenConnection(nickname, model string, capabilities []string) (*PeerListener, error) {
+	a, err := m.localAgent(nickname, model)
 	if err != nil {
 		return nil, err
 	}
@@ -29,55 +46,185 @@ func (m *ConnectionManager) ListenConnection(nickname string) (*PeerListener, er
 		return nil, err
 	}
 
-	listener, err := ospc.Listen(ospc.AgentTransportQUIC, a)
+	txt := ospc.TXTRecordSet{}
+	if model != "" {
+		txt.Set(txtKeyModel, model)
+	}
+	for _, capability := range capabilities {
+		txt.Add(txtKeyCapability, capability)
+	}
+	l := &PeerListener{
+		m:       m,
+		granted: make(map[ospc.PeerID]*GrantedConnection),
+		close:   make(chan struct{}),
+	}
+	l.connListener = ospc.NewListener(a, ospc.AgentTransportQUIC, &ospc.ListenerConfig{
+		TXT: txt,
+	})
+	if m.advertiser != nil {
+		l.connListener.WithDiscoveryProvider(m.advertiser)
+	}
+	// Dedicated transports are only accepted from peers with a granted
+	// connection.
+	l.transportListener = l.connListener.ListenApplication(ALPNWebTransport, &ospc.ALPNListenerConfig{
+		VerifyConnection: l.verifyGranted,
+	})
+	err = l.connListener.Start()
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen: %s", err)
 	}
 
-	return &PeerListener{
-		m:            m,
-		connListener: listener,
-		close:        make(chan struct{}),
-	}, nil
+	go l.acceptTransports()
+
+	return l, nil
 }
 
-// AcceptConnection new connections
-func (l *PeerListener) AcceptConnection(ctx context.Context) (*ospc.Connection, error) {
+// AcceptConnection new connections. The user is asked for consent,
+// unless the peer was permanently granted to the origin before. A
+// connection that isn't granted is closed, the listener keeps accepting.
+func (l *PeerListener) AcceptConnection(ctx context.Context) (*GrantedConnection, error) {
 	uConn, err := l.connListener.Accept(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer uConn.Close() // Cleanup of not authenticated
 
-	err = l.m.consentAccept(uConn.RemoteAgent().Info().DisplayName)
+	remote := uConn.RemoteAgent()
+	conn, trusted := uConn.Authenticated()
+
+	// Grants only hold while the trust relation does.
+	var grant OriginPeerGrant
+	granted := false
+	if trusted {
+		grant, granted = l.m.permanentGrant(remote.PeerID)
+	}
+
+	var consent Consent
+	if !granted {
+		consent, err = l.m.consentAccept(ctx, remote, trusted)
+		if err != nil {
+			if trusted {
+				_ = conn.Close()
+			}
+			return nil, err
+		}
+	}
+
+	conn, err = l.m.authenticatePSK(ctx, uConn)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := l.m.authenticatePSK(ctx, uConn)
+	if !granted {
+		grant, err = l.m.grant(remote.PeerID, consent)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	gc := &GrantedConnection{
+		Conn:       conn,
+		Grant:      grant,
+		transports: make(chan *ospc.DedicatedWebTransport),
+	}
+	l.track(gc)
+	return gc, nil
+}
+
+// track keeps the granted connection until it's closed.
+func (l *PeerListener) track(gc *GrantedConnection) {
+	id := gc.Conn.RemoteAgent().PeerID
+
+	l.mu.Lock()
+	l.granted[id] = gc
+	l.mu.Unlock()
+
+	go func() {
+		<-gc.Conn.Done()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// The peer may have connected again.
+		if l.granted[id] == gc {
+			delete(l.granted, id)
+		}
+	}()
+}
+
+func (l *PeerListener) getGranted(id ospc.PeerID) (*GrantedConnection, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	gc, ok := l.granted[id]
+	return gc, ok
+}
+
+// verifyGranted rejects the handshake of dedicated transports of peers
+// without a granted connection.
+func (l *PeerListener) verifyGranted(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	id, err := ospc.CertificatePeerID(cs.PeerCertificates[0])
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if _, ok := l.getGranted(id); !ok {
+		return fmt.Errorf("peer without granted connection: %s", id)
 	}
+	return nil
+}
 
-	return conn, nil
+// acceptTransports delivers the WebTransports over dedicated QUIC
+// connections to the granted connection of their peer.
+func (l *PeerListener) acceptTransports() {
+	for {
+		conn, err := l.transportListener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+
+		t := ospc.NewDedicatedWebTransport(conn)
+		id, err := t.RemotePeerID()
+		if err != nil {
+			_ = t.CloseWithError(0, "unknown peer")
+			continue
+		}
+		// The connection may have closed since the handshake.
+		gc, ok := l.getGranted(id)
+		if !ok {
+			_ = t.CloseWithError(0, "connection closed")
+			continue
+		}
+		go gc.deliverTransport(t)
+	}
 }
 
 // Close the listener
 func (l *PeerListener) Close() error {
+	_ = l.transportListener.Close()
 	return l.connListener.Close()
 }
 
-func (m *ConnectionManager) dial(ctx context.Context, agent *ospc.DiscoveredAgent, localNickname string) (*ospc.Connection, error) {
-	// TODO: manage agent
-	c := ospc.AgentConfig{
-		DisplayName: localNickname,
+// DialTransport opens a WebTransport over a dedicated QUIC connection
+// to the remote peer of conn.
+func DialTransport(ctx context.Context, conn *ospc.Connection) (*ospc.DedicatedWebTransport, error) {
+	qConn, err := conn.DialApplication(ctx, ALPNWebTransport)
+	if err != nil {
+		return nil, err
 	}
-	a, err := ospc.NewAgent(c)
+
+	return ospc.NewDedicatedWebTransport(qConn), nil
+}
+
+func (m *ConnectionManager) dial(ctx context.Context, agent *ospc.DiscoveredAgent, localNickname string) (*GrantedConnection, error) {
+	a, err := m.localAgent(localNickname, "")
 	if err != nil {
 		return nil, err
 	}
 
-	uConn, err := agent.Dial(context.Background(), ospc.AgentTransportQUIC, a)
+	uConn, err := agent.Dial(ctx, ospc.AgentTransportQUIC, a)
 	if err != nil {
 		return nil, err
 	}
@@ -88,5 +235,18 @@ func (m *ConnectionManager) dial(ctx context.Context, agent *ospc.DiscoveredAgen
 		return nil, err
 	}
 
-	return conn, nil
+	// The peer may be trusted now.
+	m.mu.Lock()
+	m.updatePeers()
+	m.mu.Unlock()
+
+	grant, err := m.grant(agent.PeerID, ConsentOnce)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GrantedConnection{
+		Conn:  conn,
+		Grant: grant,
+	}, nil
 }
@@ -0,0 +1,154 @@
+package ua
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+func TestPeerListenerDedicatedTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	psk := []byte("0124")
+	listenUA := &MockUserAgent{
+		IgnoreConsent: true,
+		PSKOverride:   psk,
+	}
+	m := NewConnectionManager(listenUA)
+	m.WithOrigin(testOrigin)
+	m.advertiser = ospc.NewMemoryDiscovery()
+	l, err := m.ListenConnection("Listener", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	listenAgent, err := m.localAgent("Listener", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(l.connListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("127.0.0.1", port)
+
+	requested := make(chan struct{}, 1)
+	dialer := NewConnectionManager(&MockUserAgent{
+		Consumer: func() ([]byte, error) {
+			requested <- struct{}{}
+			return psk, nil
+		},
+	})
+	dialAgent, err := dialer.localAgent("Dialer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// connect dials the listener and accepts the connection.
+	connect := func() (*ospc.Connection, *GrantedConnection, error) {
+		t.Helper()
+
+		uConn, err := ospc.DialAddr(ctx, addr, string(listenAgent.PeerID), ospc.AgentTransportQUIC, dialAgent, ospc.WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		type result struct {
+			conn *ospc.Connection
+			err  error
+		}
+		dResult := make(chan result, 1)
+		go func() {
+			conn, err := dialer.authenticatePSK(ctx, uConn)
+			dResult <- result{conn: conn, err: err}
+		}()
+		// The presenting listener waits for the collecting dialer.
+		if _, trusted := uConn.Authenticated(); !trusted {
+			<-requested
+		}
+
+		granted, err := l.AcceptConnection(ctx)
+		res := <-dResult
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		t.Cleanup(func() { _ = res.conn.Close() })
+		return res.conn, granted, err
+	}
+
+	// rejected determines if a dedicated transport opened by the dialer
+	// is rejected. Client certificates are verified after the handshake
+	// completed for the dialer, a rejected transport is closed.
+	rejected := func(conn *ospc.Connection) bool {
+		wt, err := DialTransport(ctx, conn)
+		if err != nil {
+			return true
+		}
+		defer wt.CloseWithError(0, "")
+		select {
+		case <-wt.Context().Done():
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	// A paired peer needs a granted connection to open a dedicated
+	// transport.
+	conn, granted, err := connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = granted.Conn.Close()
+	for {
+		if _, ok := l.getGranted(dialAgent.PeerID); !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !rejected(conn) {
+		t.Fatal("dedicated transport accepted without connection")
+	}
+
+	listenUA.IgnoreConsent = false
+	conn, _, err = connect()
+	if err == nil {
+		t.Fatal("expected consent to be denied")
+	}
+	if !rejected(conn) {
+		t.Fatal("dedicated transport accepted from denied peer")
+	}
+	// The denied connection is closed.
+	select {
+	case <-conn.Done():
+	case <-ctx.Done():
+		t.Fatal("denied connection not closed")
+	}
+
+	// The transport is delivered through the granted connection.
+	listenUA.IgnoreConsent = true
+	conn, granted, err = connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer granted.Conn.Close()
+	wt, err := DialTransport(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wt.CloseWithError(0, "")
+	dt, err := granted.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := dt.RemotePeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != dialAgent.PeerID {
+		t.Fatalf("unexpected peer: %s", id)
+	}
+}
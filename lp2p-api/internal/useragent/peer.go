@@ -1,6 +1,9 @@
 package ua
 
 import (
+	"context"
+	"time"
+
 	"github.com/backkem/go-lp2p/openscreen-go/network"
 )
 
@@ -12,12 +15,71 @@ type PeerContext struct {
 
 // OriginPeerGrant represents a peer grant to an origin.
 type OriginPeerGrant struct {
-	ID     ospc.PeerID
-	Origin string
+	ID     ospc.PeerID `json:"peer_id"`
+	Origin string      `json:"origin"`
+	// TrustedAt identifies the trust relation with the peer the grant is
+	// tied to. Once it's re-established, the grant is revoked.
+	TrustedAt time.Time `json:"trusted_at"`
+	GrantedAt time.Time `json:"granted_at"`
+	// Permanent grants are stored, one-time grants only cover a single
+	// connection.
+	Permanent bool `json:"permanent"`
 }
 
 // GrantedConnection represents a connection to a remote peer
 // that has been granted to an origin by the user agent.
 type GrantedConnection struct {
-	Conn *ospc.Connection
+	Conn  *ospc.Connection
+	Grant OriginPeerGrant
+
+	// transports receives the dedicated transports of the peer, only
+	// for accepted connections.
+	transports chan *ospc.DedicatedWebTransport
+}
+
+// AcceptTransport accepts the WebTransports the peer opens over dedicated
+// QUIC connections while the connection is open. Only the dialing peer
+// can open them, on a dialed connection it blocks until it's closed.
+func (c *GrantedConnection) AcceptTransport(ctx context.Context) (*ospc.DedicatedWebTransport, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.Conn.Done():
+		return nil, ospc.ErrConnectionClosed
+	case t := <-c.transports:
+		return t, nil
+	}
+}
+
+// deliverTransport hands the transport to AcceptTransport. It's closed if
+// the connection closes first.
+func (c *GrantedConnection) deliverTransport(t *ospc.DedicatedWebTransport) {
+	select {
+	case c.transports <- t:
+	case <-c.Conn.Done():
+		_ = t.CloseWithError(0, "connection closed")
+	}
+}
+
+// Peer represents a discovered remote peer.
+type Peer struct {
+	ID       ospc.PeerID
+	Nickname string
+	// Model is the advertised model name. For peers that don't advertise
+	// it, it's only known if they were paired before.
+	Model string
+	// Trusted peers were paired before and skip PSK authentication.
+	Trusted bool
+	// Capabilities advertised by the peer.
+	Capabilities []string
+}
+
+// HasCapability determines if the peer advertises the capability.
+func (p Peer) HasCapability(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
 }
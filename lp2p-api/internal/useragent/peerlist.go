@@ -0,0 +1,64 @@
+package ua
+
+import (
+	"context"
+	"sync"
+)
+
+// PeerList is the live list of discovered peers.
+type PeerList struct {
+	mu      sync.Mutex
+	peers   []Peer
+	changed chan struct{}
+}
+
+func newPeerList() *PeerList {
+	return &PeerList{
+		changed: make(chan struct{}),
+	}
+}
+
+// Peers returns the peers that are currently discovered.
+func (l *PeerList) Peers() []Peer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Peer(nil), l.peers...)
+}
+
+// Changed returns a channel that is closed once the list changes.
+func (l *PeerList) Changed() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.changed
+}
+
+// Wait blocks until at least one peer is discovered.
+func (l *PeerList) Wait(ctx context.Context) ([]Peer, error) {
+	for {
+		l.mu.Lock()
+		peers := append([]Peer(nil), l.peers...)
+		changed := l.changed
+		l.mu.Unlock()
+
+		if len(peers) > 0 {
+			return peers, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (l *PeerList) set(peers []Peer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.peers = peers
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
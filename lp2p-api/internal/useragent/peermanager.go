@@ -2,8 +2,10 @@ package ua
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/backkem/go-lp2p/openscreen-go/network"
 )
@@ -21,48 +23,172 @@ import (
 //     to be re-established, all corresponding origin-grants must be revoked.
 //     A user can indicate if they want the origin grant to be permanent or 1-time.
 type ConnectionManager struct {
-	ua         *mockUserAgent
-	discoverer *ospc.Discoverer
+	ua UserAgent
+	// trustStore holds the peers that were paired before.
+	trustStore ospc.TrustStore
+	// identity is the file the key of the local agent is persisted to.
+	identity string
+	agent    *ospc.Agent // Created on first use
+
+	// Consent
+	origin string
+	grants GrantStore
+
+	// advertiser announces the listeners, defaults to mDNS.
+	advertiser ospc.DiscoveryProvider
 
 	// Discovery
+	mu               sync.Mutex
+	discoverer       *ospc.Discoverer // nil once discovery stopped
 	discoveredAgents map[ospc.PeerID]*ospc.DiscoveredAgent
+	peers            *PeerList
+	filters          []PeerFilter
 }
 
-func NewConnectionManager(ua *mockUserAgent) *ConnectionManager {
+func NewConnectionManager(ua UserAgent) *ConnectionManager {
 	return &ConnectionManager{
 		ua:               ua,
+		trustStore:       ospc.NewMemoryTrustStore(),
+		grants:           NewMemoryGrantStore(),
 		discoveredAgents: make(map[ospc.PeerID]*ospc.DiscoveredAgent),
+		peers:            newPeerList(),
 	}
 }
 
-func (m *ConnectionManager) run() {
-	var err error
-	m.discoverer, err = ospc.Discover()
-	if err != nil {
-		panic(err) // TODO: Handle
-	}
+// WithOrigin sets the origin connections are granted to.
+func (m *ConnectionManager) WithOrigin(origin string) {
+	m.origin = origin
+}
+
+// WithTrustStore sets the store of the peers that were paired before.
+// Defaults to a MemoryTrustStore. Needs to be set before listening or
+// discovering.
+func (m *ConnectionManager) WithTrustStore(s ospc.TrustStore) {
+	m.trustStore = s
+}
+
+// WithIdentity persists the identity of the local agent to the file at
+// path. Without it, a new identity is created every run and remote peers
+// won't recognize the local peer as paired. Needs to be set before
+// listening or dialing.
+func (m *ConnectionManager) WithIdentity(path string) {
+	m.identity = path
+}
 
-	// TODO: build list of discovered peers.
-	// TODO: how to know when outdated?
-	agent, err := m.discoverer.Accept(context.Background())
+// WithGrantStore sets the store of the permanent grants. Defaults to a
+// MemoryGrantStore.
+func (m *ConnectionManager) WithGrantStore(s GrantStore) {
+	m.grants = s
+}
+
+// WithPeerFilters limits the peers in the list to the ones matching any
+// of the filters. Needs to be set before discovering.
+func (m *ConnectionManager) WithPeerFilters(filters []PeerFilter) {
+	m.filters = filters
+}
+
+// Discover starts browsing for peers. The discovered peers are tracked
+// in the background, see Peers.
+func (m *ConnectionManager) Discover() error {
+	d, err := ospc.Discover()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	m.discoveredAgents[agent.PeerID] = agent
+	m.mu.Lock()
+	m.discoverer = d
+	m.mu.Unlock()
+
+	go func() {
+		for {
+			e, err := d.AcceptEvent(context.Background())
+			if err != nil {
+				return
+			}
+
+			m.mu.Lock()
+			switch e.Type {
+			case ospc.DiscoveryEventAdded, ospc.DiscoveryEventUpdated:
+				m.discoveredAgents[e.Agent.PeerID] = e.Agent
+			case ospc.DiscoveryEventRemoved:
+				delete(m.discoveredAgents, e.Agent.PeerID)
+			}
+			m.updatePeers()
+			m.mu.Unlock()
+		}
+	}()
+	return nil
 }
 
-// PickAndDial picks a peer form the list and dials it
-func (m *ConnectionManager) PickAndDial(localNickname string) (*ospc.Connection, error) {
+// stopDiscovery stops browsing for peers. The peer list keeps the peers
+// discovered so far.
+func (m *ConnectionManager) stopDiscovery() error {
+	m.mu.Lock()
+	d := m.discoverer
+	m.discoverer = nil
+	m.mu.Unlock()
 
-	// TODO: Render (dynamic) discovered peers & allow user to pick one.
-	if len(m.discoveredAgents) < 1 {
-		panic("no agent")
+	if d == nil {
+		return nil
 	}
-	var agent *ospc.DiscoveredAgent
+	return d.Close()
+}
+
+// Close stops discovering peers.
+func (m *ConnectionManager) Close() error {
+	return m.stopDiscovery()
+}
+
+// Peers returns the live list of discovered peers.
+func (m *ConnectionManager) Peers() *PeerList {
+	return m.peers
+}
+
+// updatePeers refreshes the peer list. Caller should hold the lock.
+func (m *ConnectionManager) updatePeers() {
+	peers := make([]Peer, 0, len(m.discoveredAgents))
 	for _, v := range m.discoveredAgents {
-		agent = v // Just pick one for now.
-		break
+		peer := Peer{
+			ID:           v.PeerID,
+			Nickname:     v.Nickname(),
+			Capabilities: v.TXT.Get(txtKeyCapability),
+		}
+		peer.Model, _ = v.TXT.GetOne(txtKeyModel)
+		if trusted, ok := m.trustStore.Get(v.PeerID); ok {
+			if peer.Model == "" {
+				peer.Model = trusted.ModelName
+			}
+			peer.Trusted = true
+		}
+		if !matchPeer(m.filters, peer) {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	// A stable order keeps the rendered list from jumping around.
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].Nickname != peers[j].Nickname {
+			return peers[i].Nickname < peers[j].Nickname
+		}
+		return peers[i].ID < peers[j].ID
+	})
+	m.peers.set(peers)
+}
+
+// PickAndDial lets the user pick a peer from the list and dials it.
+// Picking the peer grants it to the origin once. Discovery stops once
+// the peer is connected.
+func (m *ConnectionManager) PickAndDial(localNickname string) (*GrantedConnection, error) {
+	peer, err := m.ua.SelectPeer(context.Background(), m.peers)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	agent, ok := m.discoveredAgents[peer.ID]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("peer %s is gone", peer.ID)
 	}
 
 	conn, err := m.dial(context.Background(), agent, localNickname)
@@ -70,25 +196,65 @@ func (m *ConnectionManager) PickAndDial(localNickname string) (*ospc.Connection,
 		return nil, err
 	}
 
+	_ = m.stopDiscovery()
+
 	return conn, nil
 }
 
+// localAgent returns the local agent, listening and dialing share it.
+func (m *ConnectionManager) localAgent(nickname, model string) (*ospc.Agent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.agent != nil {
+		return m.agent, nil
+	}
+
+	c := ospc.AgentConfig{
+		DisplayName: nickname,
+		ModelName:   model,
+		TrustStore:  m.trustStore,
+	}
+	var a *ospc.Agent
+	var err error
+	if m.identity != "" {
+		a, err = ospc.LoadOrCreateAgent(m.identity, c)
+	} else {
+		a, err = ospc.NewAgent(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.agent = a
+	return a, nil
+}
+
 func (m *ConnectionManager) authenticatePSK(ctx context.Context, uConn *ospc.UnauthenticatedConnection) (*ospc.Connection, error) {
+	// Trusted peers skip PSK authentication.
+	if conn, ok := uConn.Authenticated(); ok {
+		return conn, nil
+	}
+
 	role := uConn.GetAuthenticationRole()
 
 	var psk []byte
 	var err error
 	if role == ospc.AuthenticationRolePresenter {
-		if m.ua.PSKOverride != nil {
-			psk = m.ua.PSKOverride
-		} else {
+		if g, ok := m.ua.(PSKGenerator); ok {
+			psk, err = g.GeneratePSK()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if psk == nil {
 			psk, err = uConn.GeneratePSK()
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		m.present(psk)
+		m.ua.PresentPSK(psk)
 
 	} else {
 		err := uConn.RequestAuthenticatePSK()
@@ -96,7 +262,7 @@ func (m *ConnectionManager) authenticatePSK(ctx context.Context, uConn *ospc.Una
 			return nil, err
 		}
 
-		psk, err = m.consume()
+		psk, err = m.ua.CollectPSK(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -107,42 +273,69 @@ func (m *ConnectionManager) authenticatePSK(ctx context.Context, uConn *ospc.Una
 		return nil, err
 	}
 
+	// The trust relation is re-established, the grants tied to the
+	// previous one are revoked.
+	err = m.grants.RevokePeer(conn.RemoteAgent().PeerID)
+	if err != nil {
+		return nil, err
+	}
+
 	return conn, nil
 }
 
 func (m *ConnectionManager) consentListen(nickname string) error {
-	if m.ua.IgnoreConsent {
-		return nil
-	}
-
-	consent := ""
-	fmt.Printf("Accept connections as %s? (y/n):\n", nickname)
-	fmt.Scanln(&consent)
+	return m.ua.ConsentListen(m.origin, nickname)
+}
 
-	if consent != "y" {
-		return errors.New("access denied by user")
+func (m *ConnectionManager) consentAccept(ctx context.Context, remote *ospc.Agent, trusted bool) (Consent, error) {
+	info := remote.Info()
+	consent, err := m.ua.ConsentAccept(ctx, m.origin, Peer{
+		ID:       remote.PeerID,
+		Nickname: info.DisplayName,
+		Model:    info.ModelName,
+		Trusted:  trusted,
+	})
+	if err != nil {
+		return ConsentDenied, err
 	}
-	return nil
+	if consent == ConsentDenied {
+		return ConsentDenied, errConsentDenied
+	}
+	return consent, nil
 }
 
-func (m *ConnectionManager) consentAccept(nickname string) error {
-	if m.ua.IgnoreConsent {
-		return nil
+// permanentGrant returns the permanent grant of the peer to the origin.
+// Grants tied to a previous trust relation are revoked.
+func (m *ConnectionManager) permanentGrant(id ospc.PeerID) (OriginPeerGrant, bool) {
+	grant, ok := m.grants.Get(m.origin, id)
+	if !ok {
+		return OriginPeerGrant{}, false
 	}
-	consent := ""
-	fmt.Printf("Accept connection from %s? (y/n):\n", nickname)
-	fmt.Scanln(&consent)
 
-	if consent != "y" {
-		return errors.New("access denied by user")
+	trusted, ok := m.trustStore.Get(id)
+	if !ok || !trusted.TrustedAt.Equal(grant.TrustedAt) {
+		_ = m.grants.Remove(m.origin, id)
+		return OriginPeerGrant{}, false
 	}
-	return nil
-}
-
-func (m *ConnectionManager) present(psk []byte) {
-	m.ua.Presenter(psk)
+	return grant, true
 }
 
-func (m *ConnectionManager) consume() ([]byte, error) {
-	return m.ua.Consumer()
+// grant grants the authenticated peer to the origin. Permanent grants
+// are stored.
+func (m *ConnectionManager) grant(id ospc.PeerID, consent Consent) (OriginPeerGrant, error) {
+	trusted, _ := m.trustStore.Get(id)
+	grant := OriginPeerGrant{
+		ID:        id,
+		Origin:    m.origin,
+		TrustedAt: trusted.TrustedAt,
+		GrantedAt: time.Now(),
+		Permanent: consent == ConsentPermanent,
+	}
+	if grant.Permanent {
+		err := m.grants.Add(grant)
+		if err != nil {
+			return OriginPeerGrant{}, err
+		}
+	}
+	return grant, nil
 }
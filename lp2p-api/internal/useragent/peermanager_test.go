@@ -0,0 +1,240 @@
+package ua
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/backkem/go-lp2p/openscreen-go/network"
+)
+
+const testOrigin = "https://example.com"
+
+func TestPermanentGrant(t *testing.T) {
+	dir := t.TempDir()
+	trustPath := filepath.Join(dir, "trust.json")
+	grantPath := filepath.Join(dir, "grants.json")
+
+	newManager := func() (*ConnectionManager, *ospc.FileTrustStore, *FileGrantStore) {
+		trustStore, err := ospc.NewFileTrustStore(trustPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grants, err := NewFileGrantStore(grantPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewConnectionManager(&MockUserAgent{})
+		m.WithOrigin(testOrigin)
+		m.WithTrustStore(trustStore)
+		m.WithGrantStore(grants)
+		return m, trustStore, grants
+	}
+
+	m, trustStore, grants := newManager()
+	trustedAt := time.Now().Round(0)
+	err := trustStore.Add(ospc.TrustedPeer{PeerID: "foo", TrustedAt: trustedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.grant("foo", ConsentPermanent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.grant("foo", ConsentOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants.List()) != 1 {
+		t.Fatalf("wrong number of grants: %d", len(grants.List()))
+	}
+
+	// Permanent grants survive a restart.
+	m, trustStore, grants = newManager()
+	grant, ok := m.permanentGrant("foo")
+	if !ok {
+		t.Fatal("permanent grant not honoured after restart")
+	}
+	if !grant.TrustedAt.Equal(trustedAt) {
+		t.Fatalf("wrong TrustedAt: %s != %s", grant.TrustedAt, trustedAt)
+	}
+
+	// Grants tied to a previous trust relation are revoked.
+	err = trustStore.Add(ospc.TrustedPeer{PeerID: "foo", TrustedAt: trustedAt.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok = m.permanentGrant("foo")
+	if ok {
+		t.Fatal("grant of previous trust relation honoured")
+	}
+	if _, ok := grants.Get(testOrigin, "foo"); ok {
+		t.Fatal("grant of previous trust relation not revoked")
+	}
+
+	// As are grants of peers that aren't trusted anymore.
+	err = grants.Add(OriginPeerGrant{ID: "bar", Origin: testOrigin, TrustedAt: trustedAt, Permanent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok = m.permanentGrant("bar")
+	if ok {
+		t.Fatal("grant of untrusted peer honoured")
+	}
+
+	_, _, grants = newManager()
+	if len(grants.List()) != 0 {
+		t.Fatalf("revoked grants persisted: %+v", grants.List())
+	}
+}
+
+func TestAuthenticatePSKRevokesGrants(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	psk := []byte("0124")
+	newManager := func() *ConnectionManager {
+		m := NewConnectionManager(&MockUserAgent{
+			PSKOverride: psk,
+			Consumer:    func() ([]byte, error) { return psk, nil },
+		})
+		m.WithOrigin(testOrigin)
+		return m
+	}
+	listener := newManager()
+	dialer := newManager()
+
+	dialAgent, err := dialer.localAgent("Dialer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID := dialAgent.PeerID
+
+	// Grants from before the PSK authentication are tied to a previous
+	// trust relation.
+	for _, g := range []OriginPeerGrant{
+		{ID: peerID, Origin: testOrigin, Permanent: true},
+		{ID: peerID, Origin: "https://other.example.com", Permanent: true},
+		{ID: "other", Origin: testOrigin, Permanent: true},
+	} {
+		err = listener.grants.Add(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dConn, lConn := newConnectionPair(ctx, t, dialer, listener)
+	type result struct {
+		conn *ospc.Connection
+		err  error
+	}
+	dResult := make(chan result, 1)
+	go func() {
+		conn, err := dialer.authenticatePSK(ctx, dConn)
+		dResult <- result{conn: conn, err: err}
+	}()
+	// The presenting listener waits for the collecting dialer.
+	_, err = lConn.AcceptAuthenticate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listener.authenticatePSK(ctx, lConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	res := <-dResult
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer res.conn.Close()
+
+	grants := listener.grants.List()
+	if len(grants) != 1 || grants[0].ID != "other" {
+		t.Fatalf("grants of re-authenticated peer not revoked: %+v", grants)
+	}
+
+	// Grants tied to the new trust relation are kept by connections of
+	// mutually trusted peers, they skip the PSK authentication.
+	_, err = listener.grant(peerID, ConsentPermanent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dConn, lConn = newConnectionPair(ctx, t, dialer, listener)
+	if _, ok := dConn.Authenticated(); !ok {
+		t.Fatal("dialer not authenticated as trusted peer")
+	}
+	conn, err = listener.authenticatePSK(ctx, lConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := listener.permanentGrant(peerID); !ok {
+		t.Fatal("grant of trusted peer not honoured")
+	}
+}
+
+// newConnectionPair connects the local agents of the managers over the
+// loopback transport.
+func newConnectionPair(ctx context.Context, t *testing.T, dialer, listener *ConnectionManager) (*ospc.UnauthenticatedConnection, *ospc.UnauthenticatedConnection) {
+	t.Helper()
+
+	listenAgent, err := listener.localAgent("Listener", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ospc.NewListener(listenAgent, ospc.AgentTransportLoopback, nil)
+	l.WithDiscoveryProvider(ospc.NewMemoryDiscovery())
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	dialAgent, err := dialer.localAgent("Dialer", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dConn, err := ospc.DialAddr(ctx, l.Addr().String(), string(listenAgent.PeerID), ospc.AgentTransportLoopback, dialAgent, ospc.WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = dConn.Close() })
+	lConn, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = lConn.Close() })
+
+	return dConn, lConn
+}
+
+func TestLocalAgentIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+
+	newAgent := func() *ospc.Agent {
+		m := NewConnectionManager(&MockUserAgent{})
+		m.WithIdentity(path)
+		a, err := m.localAgent("Peer", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Listening and dialing share the agent.
+		b, err := m.localAgent("Peer", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != b {
+			t.Fatal("local agent not reused")
+		}
+		return a
+	}
+
+	// The identity survives a restart.
+	first := newAgent()
+	second := newAgent()
+	if first.PeerID != second.PeerID {
+		t.Fatalf("identity not persisted: %s != %s", first.PeerID, second.PeerID)
+	}
+}
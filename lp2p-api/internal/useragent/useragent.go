@@ -1,26 +1,130 @@
 // Package ua bundles the user agent logic.
 package ua
 
-// mockUserAgent represents everything a user agent provides to
-// the LP2P API.
-type mockUserAgent struct {
-	pm *ConnectionManager
+import (
+	"context"
+	"errors"
+)
 
+// UserAgent represents everything a user agent provides to
+// the LP2P API: the user interaction needed to connect peers.
+type UserAgent interface {
+	// ConsentListen asks the user to let the origin accept connections
+	// as nickname. Returning an error denies it.
+	ConsentListen(origin, nickname string) error
+	// ConsentAccept asks the user to grant a connection from the peer to
+	// the origin. It's skipped if the user permanently granted the peer
+	// to the origin before. The prompt is dismissed when ctx is done.
+	ConsentAccept(ctx context.Context, origin string, peer Peer) (Consent, error)
+
+	// PresentPSK shows the PSK to the user, for them to enter it on the
+	// other peer.
+	PresentPSK(psk []byte)
+	// CollectPSK asks the user for the PSK presented by the other peer.
+	// The prompt is dismissed when ctx is done.
+	CollectPSK(ctx context.Context) ([]byte, error)
+
+	// SelectPeer asks the user to pick one of the discovered peers. The
+	// list is live, peers come and go while the user picks.
+	SelectPeer(ctx context.Context, peers *PeerList) (Peer, error)
+}
+
+// Consent is the answer of the user to a consent prompt.
+type Consent int
+
+const (
+	// ConsentDenied denies the connection.
+	ConsentDenied Consent = iota
+	// ConsentOnce grants the peer to the origin for a single connection.
+	ConsentOnce
+	// ConsentPermanent grants the peer to the origin until the trust
+	// relation with the peer is re-established or the grant is revoked.
+	ConsentPermanent
+)
+
+func (c Consent) String() string {
+	switch c {
+	case ConsentDenied:
+		return "denied"
+	case ConsentOnce:
+		return "once"
+	case ConsentPermanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+// PSKGenerator can be implemented by a UserAgent to choose the PSK it
+// presents. A nil PSK falls back to a random one.
+type PSKGenerator interface {
+	GeneratePSK() ([]byte, error)
+}
+
+// MockUserAgent is a UserAgent without user interaction, for tests
+// and examples.
+type MockUserAgent struct {
 	IgnoreConsent bool
-	PSKOverride   []byte
-	Consumer      consumer
-	Presenter     presenter
+	// Consent is the answer to ConsentAccept if consent is ignored.
+	// Defaults to ConsentOnce.
+	Consent     Consent
+	PSKOverride []byte
+	Consumer    func() ([]byte, error)
+	Presenter   func(psk []byte)
+	// Selector picks one of the discovered peers once there are any.
+	// The first one is picked if not set.
+	Selector func(peers []Peer) (Peer, error)
 }
 
-type presenter func(psk []byte)
-type consumer func() ([]byte, error)
+var errConsentDenied = errors.New("access denied by user")
+
+// ConsentListen implements UserAgent
+func (a *MockUserAgent) ConsentListen(origin, nickname string) error {
+	if !a.IgnoreConsent {
+		return errConsentDenied
+	}
+	return nil
+}
 
-// PeerManager
-func (a *mockUserAgent) PeerManager() *ConnectionManager {
-	if a.pm == nil {
-		a.pm = NewConnectionManager(a)
-		// Start early
-		a.pm.run()
+// ConsentAccept implements UserAgent
+func (a *MockUserAgent) ConsentAccept(ctx context.Context, origin string, peer Peer) (Consent, error) {
+	if !a.IgnoreConsent {
+		return ConsentDenied, nil
+	}
+	if a.Consent == ConsentDenied {
+		return ConsentOnce, nil
+	}
+	return a.Consent, nil
+}
+
+// GeneratePSK implements PSKGenerator
+func (a *MockUserAgent) GeneratePSK() ([]byte, error) {
+	return a.PSKOverride, nil
+}
+
+// PresentPSK implements UserAgent
+func (a *MockUserAgent) PresentPSK(psk []byte) {
+	if a.Presenter != nil {
+		a.Presenter(psk)
+	}
+}
+
+// CollectPSK implements UserAgent
+func (a *MockUserAgent) CollectPSK(ctx context.Context) ([]byte, error) {
+	if a.Consumer == nil {
+		return nil, errors.New("no PSK consumer")
+	}
+	return a.Consumer()
+}
+
+// SelectPeer implements UserAgent
+func (a *MockUserAgent) SelectPeer(ctx context.Context, peers *PeerList) (Peer, error) {
+	discovered, err := peers.Wait(ctx)
+	if err != nil {
+		return Peer{}, err
+	}
+	if a.Selector != nil {
+		return a.Selector(discovered)
 	}
-	return a.pm
+	return discovered[0], nil
 }
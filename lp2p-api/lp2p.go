@@ -3,11 +3,90 @@
 //
 // This package contains the API surface itself. The LP2P API also relies
 // on the user agent to provide peer management logic. This logic is implemented
-// in the useragent package.
+// in the useragent package, the user interaction is provided by a UserAgent.
 package lp2p
 
 import (
 	ua "github.com/backkem/go-lp2p/lp2p-api/internal/useragent"
+	"github.com/backkem/go-lp2p/openscreen-go/network"
 )
 
-var DefaultUserAgent = ua.NewCLIUserAgent()
+// UserAgent provides the user interaction of the LP2P API: consent
+// prompts, PSK presentation & collection and peer selection.
+type UserAgent = ua.UserAgent
+
+// PSKGenerator can be implemented by a UserAgent to choose the PSK it
+// presents. A nil PSK falls back to a random one.
+type PSKGenerator = ua.PSKGenerator
+
+// Peer represents a discovered remote peer.
+type Peer = ua.Peer
+
+// PeerFilter limits the discovered peers the user can pick from. A peer
+// matches the filter if it matches all fields that are set.
+type PeerFilter = ua.PeerFilter
+
+// PeerList is the live list of discovered peers a UserAgent picks from.
+type PeerList = ua.PeerList
+
+// MockUserAgent is a UserAgent without user interaction, for tests
+// and examples.
+type MockUserAgent = ua.MockUserAgent
+
+// Consent is the answer of the user to a consent prompt.
+type Consent = ua.Consent
+
+const (
+	// ConsentDenied denies the connection.
+	ConsentDenied = ua.ConsentDenied
+	// ConsentOnce grants the peer to the origin for a single connection.
+	ConsentOnce = ua.ConsentOnce
+	// ConsentPermanent grants the peer to the origin until the trust
+	// relation with the peer is re-established or the grant is revoked.
+	ConsentPermanent = ua.ConsentPermanent
+)
+
+// OriginPeerGrant represents a peer grant to an origin.
+type OriginPeerGrant = ua.OriginPeerGrant
+
+// GrantStore records the permanent grants of peers to origins.
+type GrantStore = ua.GrantStore
+
+// NewMemoryGrantStore creates a GrantStore that is kept in memory only.
+func NewMemoryGrantStore() GrantStore {
+	return ua.NewMemoryGrantStore()
+}
+
+// NewFileGrantStore opens the GrantStore persisted at path.
+func NewFileGrantStore(path string) (GrantStore, error) {
+	return ua.NewFileGrantStore(path)
+}
+
+// TrustStore records the peers that were paired before.
+type TrustStore = ospc.TrustStore
+
+// NewMemoryTrustStore creates a TrustStore that is kept in memory only.
+func NewMemoryTrustStore() TrustStore {
+	return ospc.NewMemoryTrustStore()
+}
+
+// NewFileTrustStore opens the TrustStore persisted at path.
+func NewFileTrustStore(path string) (TrustStore, error) {
+	return ospc.NewFileTrustStore(path)
+}
+
+// NewCLIUserAgent creates a UserAgent that prompts on the command line.
+func NewCLIUserAgent() UserAgent {
+	return ua.NewCLIUserAgent()
+}
+
+// DefaultUserAgent is used by receivers and requests that aren't
+// configured with a UserAgent.
+var DefaultUserAgent = NewCLIUserAgent()
+
+func userAgentOrDefault(userAgent UserAgent) UserAgent {
+	if userAgent == nil {
+		return DefaultUserAgent
+	}
+	return userAgent
+}
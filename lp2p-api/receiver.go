@@ -2,9 +2,11 @@ package lp2p
 
 import (
 	"context"
+	"errors"
 	"sync"
 
 	ua "github.com/backkem/go-lp2p/lp2p-api/internal/useragent"
+	"github.com/backkem/go-lp2p/openscreen-go/network"
 	"github.com/backkem/go-lp2p/web-api"
 )
 
@@ -33,7 +35,18 @@ func NewLP2Receiver(config LP2PReceiverConfig) (*LP2PReceiver, error) {
 // Start advertising and receiving peers.
 func (r *LP2PReceiver) Start() error {
 	var err error
-	r.peerListener, err = DefaultUserAgent.PeerManager().ListenConnection(r.config.Nickname)
+	pm := ua.NewConnectionManager(userAgentOrDefault(r.config.UserAgent))
+	pm.WithOrigin(r.config.Origin)
+	if r.config.Identity != "" {
+		pm.WithIdentity(r.config.Identity)
+	}
+	if r.config.TrustStore != nil {
+		pm.WithTrustStore(r.config.TrustStore)
+	}
+	if r.config.GrantStore != nil {
+		pm.WithGrantStore(r.config.GrantStore)
+	}
+	r.peerListener, err = pm.ListenConnection(r.config.Nickname, r.config.Model, r.config.Capabilities)
 	if err != nil {
 		return err
 	}
@@ -47,16 +60,21 @@ func (r *LP2PReceiver) run() error {
 	// Connection
 	go func() {
 		for {
-			oConn, err := r.peerListener.AcceptConnection(context.Background())
-			if err != nil {
+			granted, err := r.peerListener.AcceptConnection(context.Background())
+			if errors.Is(err, ospc.ErrListenerClosed) {
 				return
 			}
+			if err != nil {
+				// The connection was rejected, e.g., consent was denied.
+				continue
+			}
 
 			r.mu.Lock()
 			transportListener := r.transportListener
 			r.mu.Unlock()
 
-			conn := newLP2PConnection(oConn)
+			conn := newLP2PConnection(granted.Conn)
+			conn.granted = granted
 			conn.run(transportListener)
 
 			r.onConnectionHandler.OnCallback(OnConnectionEvent{
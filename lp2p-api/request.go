@@ -1,20 +1,57 @@
 package lp2p
 
-import "sync"
+import (
+	"sync"
+
+	ua "github.com/backkem/go-lp2p/lp2p-api/internal/useragent"
+)
 
 type LP2PReceiverConfig struct {
 	Nickname string
+	// Origin the connections are granted to.
+	Origin string
+	// GrantStore remembers the peers the user permanently granted to the
+	// origin, they are accepted without asking again. Grants are kept in
+	// memory if not set.
+	GrantStore GrantStore
+	// Model and Capabilities are advertised, requests can filter on them.
+	Model        string
+	Capabilities []string
+	// UserAgent asks the user for consent and presents the PSK.
+	// DefaultUserAgent is used if not set.
+	UserAgent UserAgent
+	// Identity is the file the key of the receiver is persisted to, see
+	// ospc.LoadOrCreateAgent. A new key is used every run if not set.
+	Identity string
+	// TrustStore remembers the peers that were paired before. Together
+	// with Identity it lets paired peers, and their grants, survive a
+	// restart. Pairings are kept in memory if not set.
+	TrustStore TrustStore
 }
 
 type LP2PRequestConfig struct {
 	Nickname string
-	// TODO: Some device type filters (name/type/PeerID)
+	// Origin the connection is granted to.
+	Origin string
+	// UserAgent lets the user pick a peer and collects the PSK.
+	// DefaultUserAgent is used if not set.
+	UserAgent UserAgent
+	// Filters limit the peers the user can pick from to the ones matching
+	// any of the filters. All peers can be picked if not set.
+	Filters []PeerFilter
+	// Identity is the file the key of the request is persisted to, see
+	// ospc.LoadOrCreateAgent. A new key is used every run if not set.
+	Identity string
+	// TrustStore remembers the peers that were paired before, see
+	// PeerFilter.Paired. Pairings are kept in memory if not set.
+	TrustStore TrustStore
 }
 
 // LP2PRequest
 // Rename to LP2PConnectionRequest?
 type LP2PRequest struct {
 	config LP2PRequestConfig
+	pm     *ua.ConnectionManager
 
 	mu                sync.Mutex
 	transportListener *LP2PQuicTransportListener
@@ -22,18 +59,38 @@ type LP2PRequest struct {
 
 // NewLP2PRequest
 func NewLP2PRequest(config LP2PRequestConfig) (*LP2PRequest, error) {
+	for _, f := range config.Filters {
+		err := f.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pm := ua.NewConnectionManager(userAgentOrDefault(config.UserAgent))
+	pm.WithOrigin(config.Origin)
+	if config.Identity != "" {
+		pm.WithIdentity(config.Identity)
+	}
+	if config.TrustStore != nil {
+		pm.WithTrustStore(config.TrustStore)
+	}
+	pm.WithPeerFilters(config.Filters)
+
 	// Discover early
-	_ = DefaultUserAgent.PeerManager()
+	err := pm.Discover()
+	if err != nil {
+		return nil, err
+	}
 
 	return &LP2PRequest{
 		config: config,
+		pm:     pm,
 	}, nil
 }
 
 // Start the request.
 func (r *LP2PRequest) Start() (*LP2PConnection, error) {
-	pm := DefaultUserAgent.PeerManager()
-	oConn, err := pm.PickAndDial(r.config.Nickname)
+	granted, err := r.pm.PickAndDial(r.config.Nickname)
 	if err != nil {
 		return nil, err
 	}
@@ -42,12 +99,18 @@ func (r *LP2PRequest) Start() (*LP2PConnection, error) {
 	transportListener := r.transportListener
 	r.mu.Unlock()
 
-	conn := newLP2PConnection(oConn)
+	conn := newLP2PConnection(granted.Conn)
 	conn.run(transportListener)
 
 	return conn, nil
 }
 
+// Close stops looking for peers. Connections made by the request stay
+// open.
+func (r *LP2PRequest) Close() error {
+	return r.pm.Close()
+}
+
 func (r *LP2PRequest) registerLP2PQuicTransportListener(listener *LP2PQuicTransportListener) error {
 	r.mu.Lock()
 	r.transportListener = listener
@@ -3,6 +3,8 @@ package lp2p
 import (
 	"context"
 
+	ua "github.com/backkem/go-lp2p/lp2p-api/internal/useragent"
+	"github.com/backkem/go-lp2p/openscreen-go/network"
 	"github.com/backkem/go-lp2p/webtransport-api"
 )
 
@@ -41,15 +43,37 @@ func (c *LP2PConnection) NewLP2PQuicTransport(options LP2PWebTransportOptions) (
 		if err != nil {
 			return nil, err
 		}
-		t = webtransport.NewSessionAdaptor(s)
+		t = newSession(s)
 	} else {
-		// TODO: Transport over dedicated Quic conn
-		panic("todo")
+		s, err := ua.DialTransport(context.Background(), c.conn)
+		if err != nil {
+			return nil, err
+		}
+		t = newSession(s)
 	}
 
 	return createLP2PQuicTransport(t, options)
 }
 
+// ospcSession is implemented by the pooled and dedicated transports.
+type ospcSession interface {
+	webtransport.ConcreteSession[*ospc.QuicStream, *ospc.QuicReceiveStream, *ospc.QuicSendStream]
+	Stats() (ospc.ConnectionStats, error)
+}
+
+// newSession adapts an ospc transport to a webtransport.Session.
+func newSession(s ospcSession) webtransport.Session {
+	a := webtransport.NewSessionAdaptor[*ospc.QuicStream, *ospc.QuicReceiveStream, *ospc.QuicSendStream](s)
+	a.WithStats(func() (webtransport.WebTransportConnectionStats, error) {
+		stats, err := s.Stats()
+		if err != nil {
+			return webtransport.WebTransportConnectionStats{}, err
+		}
+		return webtransport.WebTransportConnectionStats(stats), nil
+	})
+	return a
+}
+
 func createLP2PQuicTransport(s webtransport.Session, options LP2PWebTransportOptions) (*LP2PQuicTransport, error) {
 	conn, err := webtransport.NewTransport(s)
 	if err != nil {
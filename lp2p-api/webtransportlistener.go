@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/backkem/go-lp2p/openscreen-go/network"
 	"github.com/backkem/go-lp2p/streams-api"
 	"github.com/backkem/go-lp2p/webtransport-api"
 )
@@ -43,18 +42,19 @@ type LP2PQuicTransportListenerInit struct {
 }
 
 type incomingTransport struct {
-	Transport   *ospc.PooledWebTransport
+	Session     webtransport.Session
 	IsDedicated bool
 }
 
 // AcceptTransport new Transports
 func (l *LP2PQuicTransportListener) handleTransport(t incomingTransport) {
 	if l == nil {
+		// Nobody listens, let the remote agent know.
+		_ = t.Session.CloseWithError(0, "no listener")
 		return
 	}
-	s := webtransport.NewSessionAdaptor(t.Transport)
 
-	tp, err := createLP2PQuicTransport(s,
+	tp, err := createLP2PQuicTransport(t.Session,
 		LP2PWebTransportOptions{
 			AllowPooling: !t.IsDedicated,
 		})
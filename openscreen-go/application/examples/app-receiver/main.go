@@ -57,7 +57,7 @@ func run(name, psk string) error {
 	log.Printf("Fingerprint: %s", fp)
 
 	// Start listening
-	listener, err := ospc.Listen(ospc.AgentTransportQUIC, agent)
+	listener, err := ospc.Listen(ospc.AgentTransportQUIC, agent, nil)
 	if err != nil {
 		return fmt.Errorf("failed to start listener: %w", err)
 	}
@@ -272,7 +272,7 @@ func runGoReceiver(ctx context.Context, name, psk string, ready chan<- struct{},
 	fmt.Printf("[Go Receiver] Agent created, fingerprint: %s\n", fp)
 
 	// Start listener
-	listener, err := ospc.Listen(ospc.AgentTransportQUIC, agent)
+	listener, err := ospc.Listen(ospc.AgentTransportQUIC, agent, nil)
 	if err != nil {
 		return fmt.Errorf("failed to start listener: %w", err)
 	}
@@ -11,9 +11,12 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"time"
+
+	"github.com/pion/logging"
 )
 
 type AgentRole int
@@ -28,13 +31,17 @@ type AgentTransport int
 const (
 	AgentTransportQUIC AgentTransport = iota + 1
 	AgentTransportWebRTC
+	// AgentTransportLoopback connects agents in the same process over
+	// in-memory pipes. It is meant for tests.
+	AgentTransportLoopback
 )
 
 type AgentConfig struct {
 	// ID
-	PeerID            PeerID
-	Certificate       *tls.Certificate
-	CertificateSNBase uint32
+	PeerID               PeerID
+	Certificate          *tls.Certificate
+	CertificateSNBase    uint32
+	CertificateSNCounter uint32
 
 	// Info
 	DisplayName string
@@ -44,9 +51,31 @@ type AgentConfig struct {
 	// AuthInfo
 	PSKConfig PSKConfig
 
+	// TrustStore records peers that completed authentication. Mutually
+	// trusted peers skip PSK authentication on reconnect. Defaults
+	// to a MemoryTrustStore.
+	TrustStore TrustStore
+
 	SupportedTransports []AgentTransport
+
+	// DataChannelPolicy decides whether data channels opened by remote
+	// agents are accepted. Defaults to accepting all data channels.
+	DataChannelPolicy DataChannelPolicy
+
+	// LoggerFactory creates the loggers of the agent and its connections.
+	// Defaults to a logging.DefaultLoggerFactory, which only logs errors
+	// unless enabled through the PION_LOG_* environment variables.
+	LoggerFactory logging.LoggerFactory
 }
 
+// Logger scopes used by the agent.
+const (
+	logScopeDiscovery = "ospc-discovery"
+	logScopeAuth      = "ospc-auth"
+	logScopeTransport = "ospc-transport"
+	logScopeMessages  = "ospc-messages"
+)
+
 func NewAgentConfig(nickname string) AgentConfig {
 	return AgentConfig{
 		DisplayName: nickname,
@@ -87,12 +116,14 @@ type Agent struct {
 	info               *AgentInfo
 	authenticationInfo *AgentAuthenticationInfo
 
-	knownPeers map[PeerID]knownPeer
-}
+	trustStore TrustStore
+
+	// authInitiationToken is the at value advertised by the agent.
+	authInitiationToken string
+
+	loggerFactory logging.LoggerFactory
 
-type knownPeer struct {
-	Agent         *Agent
-	Authenticated bool
+	dataChannelPolicy DataChannelPolicy
 }
 
 type AgentAuthenticationInfo struct {
@@ -119,7 +150,7 @@ func NewAgent(c AgentConfig) (*Agent, error) {
 			return nil, err
 		}
 	}
-	certificateSNCounter := uint32(0) // TODO: Manage counter state.
+	certificateSNCounter := c.CertificateSNCounter
 
 	var peerID PeerID
 	var cert *tls.Certificate
@@ -127,8 +158,11 @@ func NewAgent(c AgentConfig) (*Agent, error) {
 		peerID = c.PeerID
 		cert = c.Certificate
 	} else {
-		var err error
-		cert, err = generateCert(c.DisplayName, certificateSNBase, certificateSNCounter)
+		privKey, err := generateKey()
+		if err != nil {
+			return nil, err
+		}
+		cert, err = generateCert(privKey, c.DisplayName, certificateSNBase, certificateSNCounter)
 		if err != nil {
 			return nil, err
 		}
@@ -172,6 +206,20 @@ func NewAgent(c AgentConfig) (*Agent, error) {
 		agent.authenticationInfo.PSKConfig.Entropy = c.PSKConfig.Entropy
 	}
 
+	agent.trustStore = c.TrustStore
+	if agent.trustStore == nil {
+		agent.trustStore = NewMemoryTrustStore()
+	}
+
+	agent.authInitiationToken = randomAT(9)
+
+	agent.dataChannelPolicy = c.DataChannelPolicy
+
+	agent.loggerFactory = c.LoggerFactory
+	if agent.loggerFactory == nil {
+		agent.loggerFactory = logging.NewDefaultLoggerFactory()
+	}
+
 	return agent, nil
 }
 
@@ -190,11 +238,6 @@ func (a *Agent) NewRemoteAgent(nc NetworkConnection) (*Agent, error) {
 	}
 	peerID := PeerID(rawPeerID)
 
-	if agent, ok := a.knownPeers[peerID]; ok {
-		// TODO: avoid re-authenticating known peers.
-		return agent.Agent, nil
-	}
-
 	return &Agent{
 		PeerID:               peerID,
 		Certificate:          cert,
@@ -204,6 +247,67 @@ func (a *Agent) NewRemoteAgent(nc NetworkConnection) (*Agent, error) {
 	}, nil
 }
 
+// AuthInitiationToken returns the auth-initiation-token of the agent. For
+// the local agent this is the at value it advertises. For a remote agent
+// it is the at value it was discovered with, if any.
+func (a *Agent) AuthInitiationToken() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.authInitiationToken
+}
+
+func (a *Agent) setAuthInitiationToken(at string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.authInitiationToken = at
+}
+
+// LoggerFactory returns the logger factory of the agent.
+func (a *Agent) LoggerFactory() logging.LoggerFactory {
+	return a.loggerFactory
+}
+
+// TrustStore returns the store of peers trusted by the agent.
+func (a *Agent) TrustStore() TrustStore {
+	return a.trustStore
+}
+
+// TrustedPeers lists the peers trusted by the agent.
+func (a *Agent) TrustedPeers() []TrustedPeer {
+	return a.trustStore.List()
+}
+
+// RevokePeer revokes the trust in a peer. Subsequent connections with
+// the peer require PSK authentication.
+func (a *Agent) RevokePeer(id PeerID) error {
+	return a.trustStore.Remove(id)
+}
+
+// IsTrusted determines if the peer is trusted by the agent.
+func (a *Agent) IsTrusted(id PeerID) bool {
+	if a.trustStore == nil {
+		return false
+	}
+	_, ok := a.trustStore.Get(id)
+	return ok
+}
+
+// Caller should not hold the agent lock.
+func (a *Agent) trustPeer(remote *Agent) error {
+	peer := TrustedPeer{
+		PeerID:    remote.PeerID,
+		TrustedAt: time.Now(),
+	}
+	if info := remote.Info(); info != nil {
+		peer.DisplayName = info.DisplayName
+		peer.ModelName = info.ModelName
+	}
+
+	return a.trustStore.Add(peer)
+}
+
 func (a *Agent) setInfo(info AgentInfo) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -260,24 +364,59 @@ func (a *Agent) AuthenticationInfo() *AgentAuthenticationInfo {
 	}
 }
 
-func generateCert(displayName string, certificateSNBase, certificateSNCounter uint32) (*tls.Certificate, error) {
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// RegenerateCertificate issues a new agent certificate for the existing
+// private key. The certificate serial number counter is incremented, as
+// required by the OpenScreen spec. The PeerID is unaffected since it is
+// derived from the public key.
+func (a *Agent) RegenerateCertificate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	privKey, ok := a.Certificate.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("unsupported private key type: %T", a.Certificate.PrivateKey)
+	}
+
+	displayName := ""
+	if a.info != nil {
+		displayName = a.info.DisplayName
+	}
+
+	counter := a.CertificateSNCounter + 1
+	cert, err := generateCert(privKey, displayName, a.CertificateSNBase, counter)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	pubKey := privKey.Public()
 
-	serialNumber := uint64(certificateSNBase)<<32 | uint64(certificateSNCounter)
+	a.Certificate = cert
+	a.CertificateSNCounter = counter
+
+	return nil
+}
 
+func generateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// agentHostname builds the OpenScreen-compliant hostname used in the agent
+// certificate: serialNumber.encodedInstanceName.encodedDomain
+func agentHostname(displayName string, serialNumber uint64) string {
 	// Encode serial number as URL-safe base64 (no padding) for hostname.
 	// Spec says RFC4648 base64, but standard base64 contains +/= which are
 	// invalid in DNS labels. See: https://github.com/w3c/openscreenprotocol/issues/365
 	snBig := new(big.Int).SetUint64(serialNumber)
 	snBytes := snBig.Bytes()
 	snBase64 := base64.RawURLEncoding.EncodeToString(snBytes)
-	
-	// Build OpenScreen-compliant hostname: serialNumber.encodedInstanceName.encodedDomain
-	cn := buildAgentHostname(snBase64, displayName, MdnsDomain)
+
+	return buildAgentHostname(snBase64, displayName, MdnsDomain)
+}
+
+func generateCert(privKey *ecdsa.PrivateKey, displayName string, certificateSNBase, certificateSNCounter uint32) (*tls.Certificate, error) {
+	pubKey := privKey.Public()
+
+	serialNumber := uint64(certificateSNBase)<<32 | uint64(certificateSNCounter)
+
+	cn := agentHostname(displayName, serialNumber)
 	names := []string{cn}
 
 	keyUsage := x509.KeyUsageDigitalSignature // | x509.KeyUsageCertSign
@@ -324,6 +463,16 @@ func (a *Agent) CertificateFingerPrint() (string, error) {
 	return certificateFingerPrint(a.Certificate.Leaf)
 }
 
+// CertificatePeerID returns the PeerID of the agent the certificate
+// belongs to, e.g., to identify the peer of an application connection.
+func CertificatePeerID(cert *x509.Certificate) (PeerID, error) {
+	fp, err := certificateFingerPrint(cert)
+	if err != nil {
+		return "", err
+	}
+	return PeerID(fp), nil
+}
+
 func certificateFingerPrint(cert *x509.Certificate) (string, error) {
 	// Per OpenScreen spec (network.bs - "Computing the Agent Fingerprint"):
 	// 1. Compute the SPKI Fingerprint of the agent certificate
@@ -3,11 +3,14 @@ package ospc
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 
 	"github.com/quic-go/quic-go"
 )
 
 type ALPNListenerConfig struct {
+	// VerifyConnection verifies incoming connections. If not set, only
+	// peers trusted by the agent are accepted.
 	VerifyConnection func(cs tls.ConnectionState) error
 }
 
@@ -49,11 +52,22 @@ func (l *ALPNListener) Accept(ctx context.Context) (quic.Connection, error) {
 
 func (l *ALPNListener) doVerifyConnection(cs tls.ConnectionState) error {
 	if l.config == nil || l.config.VerifyConnection == nil {
-		return nil
+		return l.verifyTrustedPeer(cs)
 	}
 	return l.config.VerifyConnection(cs)
 }
 
+func (l *ALPNListener) verifyTrustedPeer(cs tls.ConnectionState) error {
+	fp, err := certificateFingerPrint(cs.PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+	if !l.parent.agent.IsTrusted(PeerID(fp)) {
+		return fmt.Errorf("untrusted peer: %s", fp)
+	}
+	return nil
+}
+
 func (l *ALPNListener) dispatch(conn quic.Connection) {
 	close := l.close
 	accept := l.accept
@@ -61,6 +75,7 @@ func (l *ALPNListener) dispatch(conn quic.Connection) {
 	select {
 	case accept <- conn:
 	case <-close:
+		_ = conn.CloseWithError(0, "listener closed")
 	}
 }
 
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/rand"
 	"errors"
+	"io"
 	"math/big"
 	"sync"
+
+	"github.com/pion/logging"
 )
 
 var ErrConnectionClosed = errors.New("connection closed")
@@ -46,6 +49,15 @@ func (c *Connection) Close() error {
 	return c.base.Close()
 }
 
+// Done is closed once the connection is closed, locally or by the remote
+// agent.
+func (c *Connection) Done() <-chan struct{} {
+	c.base.mu.Lock()
+	defer c.base.mu.Unlock()
+
+	return c.base.close
+}
+
 type AgentState struct {
 	StateToken string // 8 characters in the range [0-9A-Za-z]
 	RequestId  uint64
@@ -104,18 +116,35 @@ type baseConnection struct {
 	localAgent  *Agent
 	remoteAgent *Agent
 
+	// The address and transport the connection was dialed with.
+	// Only set for the client role.
+	dialAddr      string
+	transportType AgentTransport
+
 	exchangeInfoState  *exchangeInfoState
 	authenticationRole AuthenticationRole
+	authTokenSent      bool
+	authTokenReceived  bool
+
+	// Known peer state, see msgAuthKnownPeer.
+	localTrustsRemote bool
+	remoteTrustsLocal bool
 
 	authNotify          chan struct{}
 	authenticationState *authenticationState
 
+	requests *requestTracker
+
 	connectedState *connectedState
 
 	acceptCancel context.CancelFunc
 	close        chan struct{}
 	closeErr     error
 	done         chan struct{}
+
+	log     logging.LeveledLogger
+	authLog logging.LeveledLogger
+	msgLog  logging.LeveledLogger
 }
 
 func newBaseConnection(nc NetworkConnection, localAgent *Agent, remoteAgent *Agent, role AgentRole) *baseConnection {
@@ -130,13 +159,31 @@ func newBaseConnection(nc NetworkConnection, localAgent *Agent, remoteAgent *Age
 		remoteAgent: remoteAgent,
 		netConn:     nc,
 		authNotify:  make(chan struct{}),
+		requests:    newRequestTracker(),
 		close:       make(chan struct{}),
 		done:        make(chan struct{}),
+		log:         localAgent.loggerFactory.NewLogger(logScopeTransport),
+		authLog:     localAgent.loggerFactory.NewLogger(logScopeAuth),
+		msgLog:      localAgent.loggerFactory.NewLogger(logScopeMessages),
 	}
 
 	return bConn
 }
 
+func (c *baseConnection) readMessage(r io.Reader) (interface{}, error) {
+	msg, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	c.msgLog.Tracef("<-- %T", msg)
+	return msg, nil
+}
+
+func (c *baseConnection) writeMessage(msg interface{}, w io.Writer) error {
+	c.msgLog.Tracef("--> %T", msg)
+	return writeMessage(msg, w)
+}
+
 func (c *baseConnection) RemoteAgent() *Agent {
 	return c.remoteAgent
 }
@@ -155,24 +202,38 @@ func (c *baseConnection) closeWithError(err error) error {
 
 	c.closeErr = err
 	var closingErr error
+	var transports []*PooledWebTransport
 	if c.connectedState != nil {
 		closingErr = c.connectedState.appConn.Close()
+		for _, t := range c.connectedState.transports {
+			transports = append(transports, t)
+		}
+		for id, s := range c.connectedState.negotiatedStreams {
+			_ = s.Reset()
+			delete(c.connectedState.negotiatedStreams, id)
+		}
 	} else {
 		closingErr = c.netConn.Close()
 	}
 
 	if c.exchangeInfoState != nil {
-		result := exchangeInfoResult{
+		// The result is buffered, the exchange may still be waited on.
+		c.exchangeInfoState.done <- exchangeInfoResult{
 			conn: c,
 			err:  err,
 		}
-		c.exchangeInfoState.done <- result
+		c.exchangeInfoState = nil
 	}
 
+	close(c.close)
 	done := c.done
 	close(done)
 	c.mu.Unlock()
 
+	for _, t := range transports {
+		t.shutdown(err)
+	}
+
 	// Block till runLoop is gone
 	<-done
 	return closingErr
@@ -1,6 +1,7 @@
 package ospc
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 
@@ -32,7 +33,7 @@ func (c *baseConnection) runApplication() {
 		for {
 			s, err := c.connectedState.appConn.AcceptStream(acceptCtx)
 			if err != nil {
-				fmt.Printf("AcceptStream error: %s\n", err)
+				c.log.Debugf("AcceptStream error: %s", err)
 				c.closeWithError(fmt.Errorf("acceptStream error: %v", err))
 				return
 			}
@@ -41,16 +42,66 @@ func (c *baseConnection) runApplication() {
 			c.handleApplicationStream(bStream)
 		}
 	}()
+
+	if dgConn, ok := c.connectedState.appConn.(DatagramConnection); ok {
+		go c.runDatagrams(acceptCtx, dgConn)
+	}
+}
+
+// runDatagrams hands incoming datagrams to the pooled transport they
+// belong to. Datagrams are prefixed with the ExchangeId as varint.
+func (c *baseConnection) runDatagrams(ctx context.Context, dgConn DatagramConnection) {
+	for {
+		p, err := dgConn.ReceiveDatagram(ctx)
+		if err != nil {
+			c.log.Debugf("ReceiveDatagram error: %s", err)
+			return
+		}
+
+		r := bytes.NewReader(p)
+		exchangeID, err := readVaruint(r)
+		if err != nil {
+			c.log.Debugf("dropping malformed datagram: %v", err)
+			continue
+		}
+
+		c.mu.Lock()
+		t, ok := c.connectedState.transports[exchangeID]
+		c.mu.Unlock()
+		if !ok {
+			c.log.Debugf("dropping datagram for unknown exchange %d", exchangeID)
+			continue
+		}
+
+		t.deliverDatagram(p[len(p)-r.Len():])
+	}
 }
 
 type connectedState struct {
 	appConn ApplicationConnection
 
-	acceptDataChannel     chan *DataChannel
-	acceptTransport       chan *PooledWebTransport
-	acceptTransportStream chan *baseStream
+	acceptDataChannel chan *DataChannel
+	acceptTransport   chan *PooledWebTransport
+
+	// Pooled transports by ExchangeId. Each side allocates IDs of its
+	// own parity so both sides can start transports at the same time.
+	transports     map[uint64]*PooledWebTransport
+	nextExchangeID uint64
+
+	// Data channels by ID. IDs that aren't chosen by the application are
+	// allocated like ExchangeIds.
+	dataChannels  map[uint64]*DataChannel
+	nextChannelID uint64
+	// Streams of negotiated channels the listening agent didn't create
+	// yet, by channel ID. At most negotiatedStreamBacklog are kept.
+	negotiatedStreams map[uint64]ApplicationStream
 }
 
+// negotiatedStreamBacklog is the number of streams of negotiated channels
+// that wait for the listening agent to create the channel. Further streams
+// are reset.
+const negotiatedStreamBacklog = 64
+
 func (c *baseConnection) handleApplicationStream(stream *baseStream) {
 	go func() {
 		for {
@@ -59,18 +110,18 @@ func (c *baseConnection) handleApplicationStream(stream *baseStream) {
 				return
 			}
 
-			msg, err := readMessage(stream.stream)
+			msg, err := c.readMessage(stream.stream)
 			if err == quic.ErrServerClosed {
 				return
 			} else if err != nil {
-				fmt.Printf("application protocol: failed to read message: %v\n", err)
+				c.log.Debugf("application protocol: failed to read message: %v", err)
 				// c.closeWithError(fmt.Errorf("failed to read message: %v", err))
 				return
 			}
 
 			err = handler(msg, stream)
 			if err != nil {
-				fmt.Printf("failed to handle message: %v\n", err)
+				c.log.Warnf("application protocol: failed to handle message: %v", err)
 				c.closeWithError(fmt.Errorf("failed to handle message: %v", err))
 				return
 			}
@@ -79,17 +130,43 @@ func (c *baseConnection) handleApplicationStream(stream *baseStream) {
 }
 
 func (c *baseConnection) handleDataChannelOpenRequest(msg *msgDataChannelOpenRequest, stream *baseStream) error {
-	dc := &DataChannel{
-		DataChannelParameters: DataChannelParameters{
-			Label:    msg.Label,
-			ID:       uint64(msg.ChannelId),
-			Protocol: msg.Protocol,
-		},
-		stream: stream.stream,
-	}
+	// Stop message handling for this stream
 	stream.SetHandler(nil)
 
-	// TODO: send data-exchange-start-response
+	dc := newDataChannel(c, dataChannelParametersFromRequest(msg))
+	dc.stream = stream.stream
+
+	policyErr := dc.Validate()
+	if policyErr == nil {
+		if policy := c.localAgent.dataChannelPolicy; policy != nil {
+			policyErr = policy(c.remoteAgent, dc.DataChannelParameters)
+		}
+	}
+	if policyErr == nil {
+		// Registered before the response, the remote agent can send
+		// messages as soon as it's received.
+		c.mu.Lock()
+		policyErr = c.connectedState.addDataChannel(dc)
+		c.mu.Unlock()
+	}
+
+	res := &msgDataChannelOpenResponse{
+		msgResponse: msgResponse{
+			RequestId: msg.RequestId,
+		},
+		Result: errorResult(policyErr),
+	}
+	err := c.writeMessage(res, stream.stream)
+	if err != nil {
+		c.removeDataChannel(dc)
+		return err
+	}
+	if policyErr != nil {
+		c.log.Debugf("refused data channel %q: %v", dc.Label, policyErr)
+		_ = stream.stream.Close()
+		return nil
+	}
+	dc.run()
 
 	c.mu.Lock()
 	close := c.close
@@ -104,18 +181,107 @@ func (c *baseConnection) handleDataChannelOpenRequest(msg *msgDataChannelOpenReq
 	}
 }
 
-func (c *baseConnection) handleDataTransportStartRequest(msg *msgDataTransportStartRequest, info struct{}) error {
+// handleDataChannelMessage hands a message of an unordered or partially
+// reliable data channel to the channel.
+func (c *baseConnection) handleDataChannelMessage(msg *msgDataChannelMessage, stream *baseStream) error {
+	// The stream is only used for a single message.
+	stream.SetHandler(nil)
+
+	c.mu.Lock()
+	dc, ok := c.connectedState.dataChannels[msg.ChannelId]
+	c.mu.Unlock()
+
+	if ok && dc.messageStreams() {
+		dc.deliver(msg)
+	} else {
+		c.log.Debugf("no data channel %d, dropping message", msg.ChannelId)
+	}
+
+	// Closing the stream acknowledges the message. It fails if the message
+	// was abandoned in the meantime, which doesn't affect the connection.
+	_ = stream.stream.Close()
+	return nil
+}
+
+// handleDataChannelNegotiated binds the stream of a negotiated channel to
+// the channel with the same ID. If the channel isn't created yet, the
+// stream waits for it.
+func (c *baseConnection) handleDataChannelNegotiated(msg *msgDataChannelNegotiated, stream *baseStream) error {
+	// Stop message handling for this stream
+	stream.SetHandler(nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.agentRole != AgentRoleServer {
+		c.log.Warnf("negotiated data channel %d opened by the listening agent", msg.ChannelId)
+		_ = stream.stream.Reset()
+		return nil
+	}
+	if c.closeErr != nil {
+		_ = stream.stream.Reset()
+		return nil
+	}
+
+	dc, ok := c.connectedState.dataChannels[msg.ChannelId]
+	switch {
+	case !ok:
+		if _, pending := c.connectedState.negotiatedStreams[msg.ChannelId]; pending {
+			c.log.Warnf("duplicate stream for negotiated data channel %d", msg.ChannelId)
+			_ = stream.stream.Reset()
+			return nil
+		}
+		if len(c.connectedState.negotiatedStreams) >= negotiatedStreamBacklog {
+			c.log.Warnf("too many pending negotiated data channels, dropping %d", msg.ChannelId)
+			_ = stream.stream.Reset()
+			return nil
+		}
+		c.connectedState.negotiatedStreams[msg.ChannelId] = stream.stream
+	case dc.Negotiated:
+		select {
+		case dc.negotiated <- stream.stream:
+		default:
+			c.log.Warnf("duplicate stream for negotiated data channel %d", msg.ChannelId)
+			_ = stream.stream.Reset()
+		}
+	default:
+		c.log.Warnf("data channel %d isn't negotiated", msg.ChannelId)
+		_ = stream.stream.Reset()
+	}
+	return nil
+}
+
+func (c *baseConnection) handleDataTransportStartRequest(msg *msgDataTransportStartRequest, stream *baseStream) error {
+	// The stream is only used for the start request & response.
+	stream.SetHandler(nil)
+	defer stream.stream.Close()
+
 	// TODO: msg validation
-	_, _ = msg, info
 	c.mu.Lock()
-	t, err := c.createDataTransport()
-	if err != nil {
-		c.mu.Unlock()
-		return err
+	_, exists := c.connectedState.transports[msg.ExchangeId]
+	var t *PooledWebTransport
+	if !exists {
+		var err error
+		t, err = c.createDataTransport(msg.ExchangeId)
+		if err != nil {
+			c.mu.Unlock()
+			return err
+		}
 	}
 	c.mu.Unlock()
 
-	// TODO: send data-transport-start-response
+	result := ResultSuccess
+	if exists {
+		c.log.Warnf("duplicate exchange %d, refusing transport start request", msg.ExchangeId)
+		result = ResultPermanentError
+	}
+	err := c.writeMessage(&msgDataTransportStartResponse{
+		RequestID: msg.RequestID,
+		Result:    result,
+	}, stream.stream)
+	if err != nil || exists {
+		return err
+	}
 
 	c.mu.Lock()
 	close := c.close
@@ -131,37 +297,114 @@ func (c *baseConnection) handleDataTransportStartRequest(msg *msgDataTransportSt
 }
 
 // Caller should hold the connection lock
-func (c *baseConnection) createDataTransport() (*PooledWebTransport, error) {
+func (c *baseConnection) createDataTransport(exchangeID uint64) (*PooledWebTransport, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
 	t := &PooledWebTransport{
-		conn: c,
+		conn:       c,
+		exchangeID: exchangeID,
+		accept:     make(chan *baseStream),
+		acceptUni:  make(chan *baseStream),
+		datagrams:  make(chan []byte, datagramBacklog),
+		ctx:        ctx,
+		cancel:     cancel,
+		streams:    make(map[*baseStream]*sessionStream),
 	}
+	c.connectedState.transports[exchangeID] = t
 	return t, nil
 }
 
+func (c *baseConnection) removeDataTransport(t *PooledWebTransport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connectedState.transports[t.exchangeID] == t {
+		delete(c.connectedState.transports, t.exchangeID)
+	}
+}
+
 func (c *baseConnection) handleDataTransportStreamRequest(msg *msgDataTransportStreamRequest, stream *baseStream) error {
-	// TODO: msg validation
-	_ = msg
 	// Stop message handling for this stream
 	stream.SetHandler(nil)
 
-	// TODO: send data-transport-stream-response
-
 	c.mu.Lock()
-	close := c.close
-	accept := c.connectedState.acceptTransportStream
+	t, ok := c.connectedState.transports[msg.ExchangeId]
 	c.mu.Unlock()
 
-	if accept == nil {
-		fmt.Printf("handleDataTransportStreamRequest: no transport, ignoring stream request\n")
-		return nil // No-one is listening
+	result := ResultSuccess
+	if !ok {
+		c.log.Warnf("no transport for exchange %d, refusing stream request", msg.ExchangeId)
+		result = ResultPermanentError
+	}
+	err := c.writeMessage(&msgDataTransportStreamResponse{
+		RequestID: msg.RequestID,
+		Result:    result,
+	}, stream.stream)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		_ = stream.stream.Close() // No-one is listening
+		return nil
+	}
+	if msg.Unidirectional {
+		// Only the requester writes to a unidirectional stream.
+		_ = stream.stream.Close()
 	}
 
-	select {
-	case <-close:
-		return c.err()
-	case accept <- stream:
+	return t.deliverStream(stream, msg.Unidirectional)
+}
+
+// datagramConnection returns the application connection if it supports
+// datagrams.
+func (c *baseConnection) datagramConnection() (DatagramConnection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dgConn, ok := c.connectedState.appConn.(DatagramConnection)
+	return dgConn, ok
+}
+
+// sendDataTransportClose tells the remote agent a pooled transport was
+// closed.
+func (c *baseConnection) sendDataTransportClose(exchangeID, code uint64, reason string) error {
+	if c.err() != nil {
+		// The session is gone with the connection.
+		return nil
+	}
+
+	stream, err := c.openStream(context.Background())
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return c.writeMessage(&msgDataTransportClose{
+		ExchangeId: exchangeID,
+		Code:       code,
+		Reason:     reason,
+	}, stream)
+}
+
+func (c *baseConnection) handleDataTransportClose(msg *msgDataTransportClose, stream *baseStream) error {
+	// The stream is only used for the close message.
+	stream.SetHandler(nil)
+	_ = stream.stream.Close()
+
+	c.mu.Lock()
+	t, ok := c.connectedState.transports[msg.ExchangeId]
+	c.mu.Unlock()
+
+	if !ok {
+		c.log.Debugf("no transport for exchange %d, ignoring close", msg.ExchangeId)
 		return nil
 	}
+
+	t.shutdown(&SessionError{
+		Remote: true,
+		Code:   msg.Code,
+		Reason: msg.Reason,
+	})
+	return nil
 }
 
 // Handoff the underlying quick connection for use by another protocol.
@@ -182,14 +425,32 @@ func (c *baseConnection) handleApplicationMessage(msg interface{}, stream *baseS
 	case *msgDataChannelOpenRequest:
 		err = c.handleDataChannelOpenRequest(typedMsg, stream)
 
+	case *msgDataChannelOpenResponse:
+		err = c.handleResponse(uint64(typedMsg.RequestId), typedMsg, stream)
+
+	case *msgDataChannelMessage:
+		err = c.handleDataChannelMessage(typedMsg, stream)
+
+	case *msgDataChannelNegotiated:
+		err = c.handleDataChannelNegotiated(typedMsg, stream)
+
 	case *msgDataTransportStartRequest:
-		err = c.handleDataTransportStartRequest(typedMsg, struct{}{})
+		err = c.handleDataTransportStartRequest(typedMsg, stream)
+
+	case *msgDataTransportStartResponse:
+		err = c.handleResponse(typedMsg.RequestID, typedMsg, stream)
 
 	case *msgDataTransportStreamRequest:
 		err = c.handleDataTransportStreamRequest(typedMsg, stream)
 
+	case *msgDataTransportStreamResponse:
+		err = c.handleResponse(typedMsg.RequestID, typedMsg, stream)
+
+	case *msgDataTransportClose:
+		err = c.handleDataTransportClose(typedMsg, stream)
+
 	default:
-		fmt.Printf("baseConnection: unhandled message type: %T\n", typedMsg)
+		c.msgLog.Warnf("unhandled message type: %T", typedMsg)
 	}
 
 	if err != nil {
@@ -9,9 +9,13 @@ import (
 	spake2 "github.com/backkem/spake2-go"
 )
 
+var errInvalidAuthInitiationToken = errors.New("invalid auth-initiation-token")
+
 type exchangeInfoState struct {
 	requestId uint64
-	done      chan exchangeInfoResult
+	// done receives the single result of the exchange. It's buffered so
+	// the result is kept until the caller reads it.
+	done chan exchangeInfoResult
 }
 
 type exchangeInfoResult struct {
@@ -19,33 +23,35 @@ type exchangeInfoResult struct {
 	err  error
 }
 
-func (c *baseConnection) exchangeInfo(ctx context.Context, done chan exchangeInfoResult) error {
+// exchangeInfo requests the info of the remote agent and sends the local
+// one. The returned channel receives the result once both are known or the
+// connection closes.
+func (c *baseConnection) exchangeInfo(ctx context.Context) (<-chan exchangeInfoResult, error) {
 	_ = ctx
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.exchangeInfoState != nil {
-		fmt.Println("Already requesting remote AgentInfo")
-		return nil
+		c.log.Debug("already requesting remote AgentInfo")
+		return c.exchangeInfoState.done, nil
 	}
 
-	// Auth Info
-	localAuthInfo := c.localAgent.AuthenticationInfo()
-	authMsg := &msgAuthCapabilities{
-		PskEaseOfInput:      uint64(localAuthInfo.PSKConfig.EaseOfInput),
-		PskInputMethods:     []msgPskInputMethod{PskInputMethodNumeric},
-		PskMinBitsOfEntropy: uint64(localAuthInfo.PSKConfig.Entropy),
-	}
-
-	err := writeMessage(authMsg, c.netConn)
-	if err != nil {
-		return err
+	// Known peer
+	if c.localAgent.IsTrusted(c.remoteAgent.PeerID) {
+		knownMsg := &msgAuthKnownPeer{
+			Fingerprint: string(c.remoteAgent.PeerID),
+		}
+		err := c.writeMessage(knownMsg, c.netConn)
+		if err != nil {
+			return nil, err
+		}
+		c.localTrustsRemote = true
 	}
 
 	// Remote AgentInfo
 	state := &exchangeInfoState{
 		requestId: c.agentState.nextRequestID(),
-		done:      done,
+		done:      make(chan exchangeInfoResult, 1),
 	}
 	infoMsg := &msgAgentInfoRequest{
 		msgRequest: msgRequest{
@@ -53,14 +59,30 @@ func (c *baseConnection) exchangeInfo(ctx context.Context, done chan exchangeInf
 		},
 	}
 
-	err = writeMessage(infoMsg, c.netConn)
+	err := c.writeMessage(infoMsg, c.netConn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Auth Info
+	// Sent last: once the remote agent received our auth-capabilities, it
+	// has handled our other messages. This allows mutually trusted agents
+	// to switch to the application protocol right away.
+	localAuthInfo := c.localAgent.AuthenticationInfo()
+	authMsg := &msgAuthCapabilities{
+		PskEaseOfInput:      uint64(localAuthInfo.PSKConfig.EaseOfInput),
+		PskInputMethods:     []msgPskInputMethod{PskInputMethodNumeric},
+		PskMinBitsOfEntropy: uint64(localAuthInfo.PSKConfig.Entropy),
+	}
+
+	err = c.writeMessage(authMsg, c.netConn)
+	if err != nil {
+		return nil, err
 	}
 
 	c.exchangeInfoState = state
 
-	return nil
+	return state.done, nil
 }
 
 // caller should hold connection lock.
@@ -72,15 +94,11 @@ func (c *baseConnection) checkAgentInfoComplete() {
 		state := c.exchangeInfoState
 		c.determineAuthenticationRole()
 
-		result := exchangeInfoResult{
+		// The state is cleared once the result is sent, it can't block.
+		state.done <- exchangeInfoResult{
 			conn: c,
 		}
-		select {
-		case state.done <- result:
-			c.exchangeInfoState = nil
-		case <-c.close:
-			return
-		}
+		c.exchangeInfoState = nil
 	}
 }
 
@@ -102,6 +120,15 @@ func (t AuthenticationRole) String() string {
 	}
 }
 
+// IsMutuallyTrusted determines if both agents trust each other from a
+// previous authentication. Only correct after auth-capabilities exchange.
+func (c *baseConnection) IsMutuallyTrusted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.localTrustsRemote && c.remoteTrustsLocal
+}
+
 // GetAuthenticationRole determines if the agent should act as presenter or consumer of the PSK.
 // Only correct after auth-capabilities exchange.
 func (c *baseConnection) GetAuthenticationRole() AuthenticationRole {
@@ -267,25 +294,70 @@ func (c *baseConnection) doAuthNotify() {
 }
 
 // Caller should hold connection lock.
-func (c *baseConnection) getAuthInitiationToken() (string, error) {
-	// TODO: wire up auth-initiation-token
+func (c *baseConnection) getAuthInitiationToken() string {
 	// For an advertising agent, the at field in its mDNS TXT record must be used as the
 	// auth-initiation-token in the the first authentication message sent to or from that agent.
-	at := "todo"
+	if c.agentRole == AgentRoleServer {
+		return c.localAgent.AuthInitiationToken()
+	}
+	return c.remoteAgent.AuthInitiationToken()
+}
 
-	return at, nil
+// nextAuthInitiationToken returns the auth-initiation-token for the first
+// authentication message sent to the remote agent. Subsequent messages
+// don't carry the token.
+// Caller should hold connection lock.
+func (c *baseConnection) nextAuthInitiationToken() string {
+	if c.authTokenSent {
+		return ""
+	}
+	c.authTokenSent = true
+
+	return c.getAuthInitiationToken()
 }
 
 // Caller should hold connection lock.
 func (c *baseConnection) validateAuthInitiationToken(token string) error {
-	// TODO: wire up auth-initiation-token
+	if c.agentRole == AgentRoleServer && !c.authTokenReceived {
+		// The advertising agent only authenticates agents that know its at,
+		// the first authentication message it receives must carry it.
+		expected := c.localAgent.AuthInitiationToken()
+		if token == "" || expected == "" || token != expected {
+			return errInvalidAuthInitiationToken
+		}
+		c.authTokenReceived = true
+		return nil
+	}
+
 	// Agents should discard any authentication message whose auth-initiation-token is set and
 	// does not match the at provided by the advertising agent.
-	if token == "todo" {
+	if token == "" {
+		return nil
+	}
+
+	expected := c.getAuthInitiationToken()
+	if expected == "" {
+		// Remote agent wasn't discovered through its advertisement.
 		return nil
 	}
 
-	return errors.New("invalid auth-initiation-token")
+	if token != expected {
+		return errInvalidAuthInitiationToken
+	}
+
+	return nil
+}
+
+// rejectAuthInitiationToken decides what to do with an authentication message
+// that failed validateAuthInitiationToken. The advertising agent rejects the
+// connection, other agents ignore the message.
+// Caller should hold connection lock.
+func (c *baseConnection) rejectAuthInitiationToken(msgType string, err error) error {
+	if c.agentRole == AgentRoleServer {
+		return fmt.Errorf("rejecting %s: %w", msgType, err)
+	}
+	c.authLog.Warnf("ignoring %s: %v", msgType, err)
+	return nil
 }
 
 // Authenticate is used to authenticate. It will block until authentication is complete
@@ -320,6 +392,35 @@ func (c *baseConnection) finishAuthentication() (*Connection, error) {
 		return nil, fmt.Errorf("authentication failed: %d", c.authenticationState.remoteResult)
 	}
 
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.localAgent.trustPeer(c.remoteAgent)
+	if err != nil {
+		c.authLog.Warnf("failed to store trusted peer: %v", err)
+	}
+
+	return conn, nil
+}
+
+// finishTrustedAuthentication skips the PSK authentication for mutually
+// trusted agents. The agent certificates were already verified against
+// the trusted fingerprints during the TLS handshake.
+func (c *baseConnection) finishTrustedAuthentication() (*Connection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.localTrustsRemote || !c.remoteTrustsLocal {
+		return nil, errors.New("remote agent is not mutually trusted")
+	}
+
+	return c.connect()
+}
+
+// Caller should hold connection lock.
+func (c *baseConnection) connect() (*Connection, error) {
 	appConn, err := c.netConn.IntoApplicationConnection()
 	if err != nil {
 		return nil, err
@@ -328,10 +429,18 @@ func (c *baseConnection) finishAuthentication() (*Connection, error) {
 	go c.runApplication()
 
 	c.connectedState = &connectedState{
-		appConn:               appConn,
-		acceptDataChannel:     make(chan *DataChannel),
-		acceptTransport:       make(chan *PooledWebTransport),
-		acceptTransportStream: make(chan *baseStream),
+		appConn:           appConn,
+		acceptDataChannel: make(chan *DataChannel),
+		acceptTransport:   make(chan *PooledWebTransport),
+		transports:        make(map[uint64]*PooledWebTransport),
+		dataChannels:      make(map[uint64]*DataChannel),
+		negotiatedStreams: make(map[uint64]ApplicationStream),
+	}
+	// The client allocates even ExchangeIds and channel IDs, the server
+	// odd ones.
+	if c.agentRole == AgentRoleServer {
+		c.connectedState.nextExchangeID = 1
+		c.connectedState.nextChannelID = 1
 	}
 
 	return &Connection{
@@ -376,26 +485,26 @@ func (c *baseConnection) authenticatePSKProgress() error {
 		}
 	}
 
-	fmt.Printf("[Auth] role=%s status=%s\n", c.authenticationRole, c.authenticationState.status)
+	c.authLog.Tracef("role=%s status=%s", c.authenticationRole, authState.status)
 
 	role := c.authenticationRole
 
 	if authState.status == authStatusNew {
-		fmt.Printf("[Auth] Entering authStatusNew, role=%s\n", role)
+		c.authLog.Tracef("Entering authStatusNew, role=%s", role)
 		if role == AuthenticationRolePresenter {
 			if authState.localPSK == nil {
-				fmt.Printf("[Auth] Presenter: no PSK yet, notifying\n")
+				c.authLog.Tracef("Presenter: no PSK yet, notifying")
 				c.doAuthNotify()
 			}
 		} else {
 			if authState.remotePublic == nil {
-				fmt.Printf("[Auth] Consumer: no remote public yet, sending NeedPsk\n")
+				c.authLog.Tracef("Consumer: no remote public yet, sending NeedPsk")
 				err := c.sendAuthSpake2NeedPsk()
 				if err != nil {
 					return err
 				}
 			} else if authState.localPSK == nil {
-				fmt.Printf("[Auth] Consumer: have remote public but no PSK, notifying\n")
+				c.authLog.Tracef("Consumer: have remote public but no PSK, notifying")
 				c.doAuthNotify()
 			}
 		}
@@ -405,27 +514,27 @@ func (c *baseConnection) authenticatePSKProgress() error {
 
 	if authState.status == authStatusAwaitPSK {
 		if authState.localPSK == nil {
-			fmt.Printf("[Auth] AwaitPSK: still waiting for PSK\n")
+			c.authLog.Tracef("AwaitPSK: still waiting for PSK")
 			return nil // continue waiting
 		}
-		fmt.Printf("[Auth] AwaitPSK: have PSK (%d bytes), role=%s\n", len(authState.localPSK), role)
+		c.authLog.Tracef("AwaitPSK: have PSK (%d bytes), role=%s", len(authState.localPSK), role)
 		if role == AuthenticationRolePresenter {
 			clientOpts := &spake2.Options{
 				Ciphersuite: spake2.DefaultCiphersuite(),
 				IdentityA:   []byte(c.localAgent.PeerID),
 				IdentityB:   []byte(c.remoteAgent.PeerID),
 			}
-			fmt.Printf("[Auth] Presenter SPAKE2 Client: IdentityA(local)=%x IdentityB(remote)=%x\n", clientOpts.IdentityA, clientOpts.IdentityB)
+			c.authLog.Tracef("Presenter SPAKE2 Client: IdentityA(local)=%x IdentityB(remote)=%x", clientOpts.IdentityA, clientOpts.IdentityB)
 			client := spake2.NewClient(authState.localPSK, clientOpts)
 			authState.spakeState = client
 			localPublic, err := client.Start()
 			if err != nil {
-				fmt.Printf("[Auth] SPAKE2 Client.Start() failed: %v\n", err)
+				c.authLog.Debugf("SPAKE2 Client.Start() failed: %v", err)
 				status := AuthStatusResultUnknownError
 				authState.localResult = &status
 				return err
 			}
-			fmt.Printf("[Auth] SPAKE2 Client.Start() ok, public=%d bytes\n", len(localPublic))
+			c.authLog.Tracef("SPAKE2 Client.Start() ok, public=%d bytes", len(localPublic))
 
 			err = c.sendAuthSpake2Handshake(localPublic)
 			if err != nil {
@@ -438,20 +547,20 @@ func (c *baseConnection) authenticatePSKProgress() error {
 
 	if authState.status == authStatusAwaitHandshake {
 		if authState.remotePublic == nil {
-			fmt.Printf("[Auth] AwaitHandshake: still waiting for remote public\n")
+			c.authLog.Tracef("AwaitHandshake: still waiting for remote public")
 			return nil // continue waiting
 		}
-		fmt.Printf("[Auth] AwaitHandshake: have remote public (%d bytes), role=%s\n", len(authState.remotePublic), role)
+		c.authLog.Tracef("AwaitHandshake: have remote public (%d bytes), role=%s", len(authState.remotePublic), role)
 		if role == AuthenticationRolePresenter {
 			client := authState.spakeState
 			localConfirmation, err := client.Finish(authState.remotePublic)
 			if err != nil {
-				fmt.Printf("[Auth] SPAKE2 Client.Finish() failed: %v\n", err)
+				c.authLog.Debugf("SPAKE2 Client.Finish() failed: %v", err)
 				status := AuthStatusResultUnknownError
 				authState.localResult = &status
 				return err
 			}
-			fmt.Printf("[Auth] SPAKE2 Client.Finish() ok, confirmation=%d bytes\n", len(localConfirmation))
+			c.authLog.Tracef("SPAKE2 Client.Finish() ok, confirmation=%d bytes", len(localConfirmation))
 
 			err = c.sendAuthSpake2Confirmation(localConfirmation)
 			if err != nil {
@@ -463,18 +572,18 @@ func (c *baseConnection) authenticatePSKProgress() error {
 				IdentityA:   []byte(c.remoteAgent.PeerID),
 				IdentityB:   []byte(c.localAgent.PeerID),
 			}
-			fmt.Printf("[Auth] Consumer SPAKE2 Server: IdentityA(remote)=%x IdentityB(local)=%x\n", serverOpts.IdentityA, serverOpts.IdentityB)
+			c.authLog.Tracef("Consumer SPAKE2 Server: IdentityA(remote)=%x IdentityB(local)=%x", serverOpts.IdentityA, serverOpts.IdentityB)
 			server := spake2.NewServer(authState.localPSK, serverOpts)
 			authState.spakeState = server
 
 			localPublic, err := server.Exchange(authState.remotePublic)
 			if err != nil {
-				fmt.Printf("[Auth] SPAKE2 Server.Exchange() failed: %v\n", err)
+				c.authLog.Debugf("SPAKE2 Server.Exchange() failed: %v", err)
 				status := AuthStatusResultUnknownError
 				authState.localResult = &status
 				return err
 			}
-			fmt.Printf("[Auth] SPAKE2 Server.Exchange() ok, public=%d bytes\n", len(localPublic))
+			c.authLog.Tracef("SPAKE2 Server.Exchange() ok, public=%d bytes", len(localPublic))
 
 			err = c.sendAuthSpake2Handshake(localPublic)
 			if err != nil {
@@ -487,16 +596,16 @@ func (c *baseConnection) authenticatePSKProgress() error {
 
 	if authState.status == authStatusAwaitConfirmation {
 		if authState.remoteConfirmation == nil {
-			fmt.Printf("[Auth] AwaitConfirmation: still waiting for remote confirmation\n")
+			c.authLog.Tracef("AwaitConfirmation: still waiting for remote confirmation")
 			return nil // continue waiting
 		}
-		fmt.Printf("[Auth] AwaitConfirmation: have remote confirmation (%d bytes), role=%s\n", len(authState.remoteConfirmation), role)
+		c.authLog.Tracef("AwaitConfirmation: have remote confirmation (%d bytes), role=%s", len(authState.remoteConfirmation), role)
 
 		if role == AuthenticationRolePresenter {
 			client := authState.spakeState
 			err := client.Verify(authState.remoteConfirmation)
 			if err != nil {
-				fmt.Printf("[Auth] SPAKE2 Client.Verify() failed: %v\n", err)
+				c.authLog.Debugf("SPAKE2 Client.Verify() failed: %v", err)
 				status := AuthStatusResultUnknownError
 				if err == spake2.ErrInvalidConfirmation {
 					status = AuthStatusResultProofInvalid
@@ -504,12 +613,12 @@ func (c *baseConnection) authenticatePSKProgress() error {
 				authState.localResult = &status
 				return err
 			}
-			fmt.Printf("[Auth] SPAKE2 Client.Verify() ok\n")
+			c.authLog.Tracef("SPAKE2 Client.Verify() ok")
 		} else {
 			server := authState.spakeState
 			localConfirmation, err := server.Confirm(authState.remoteConfirmation)
 			if err != nil {
-				fmt.Printf("[Auth] SPAKE2 Server.Confirm() failed: %v\n", err)
+				c.authLog.Debugf("SPAKE2 Server.Confirm() failed: %v", err)
 				status := AuthStatusResultUnknownError
 				if err == spake2.ErrInvalidConfirmation {
 					status = AuthStatusResultProofInvalid
@@ -517,7 +626,7 @@ func (c *baseConnection) authenticatePSKProgress() error {
 				authState.localResult = &status
 				return err
 			}
-			fmt.Printf("[Auth] SPAKE2 Server.Confirm() ok, confirmation=%d bytes\n", len(localConfirmation))
+			c.authLog.Tracef("SPAKE2 Server.Confirm() ok, confirmation=%d bytes", len(localConfirmation))
 
 			err = c.sendAuthSpake2Confirmation(localConfirmation)
 			if err != nil {
@@ -532,7 +641,6 @@ func (c *baseConnection) authenticatePSKProgress() error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("[Auth] %v: shared secret: %x\n", role, authState.sharedSecret[:8])
 
 		err = c.sendAuthStatus()
 		if err != nil {
@@ -544,10 +652,10 @@ func (c *baseConnection) authenticatePSKProgress() error {
 
 	if authState.status == authStatusAwaitResult {
 		if authState.remoteResult == nil {
-			fmt.Printf("[Auth] AwaitResult: still waiting for remote result\n")
+			c.authLog.Tracef("AwaitResult: still waiting for remote result")
 			return nil // continue waiting
 		}
-		fmt.Printf("[Auth] AwaitResult: got remote result\n")
+		c.authLog.Tracef("AwaitResult: got remote result")
 
 		close(authState.done)
 
@@ -555,7 +663,7 @@ func (c *baseConnection) authenticatePSKProgress() error {
 	}
 
 	if authState.status == authStatusDone {
-		fmt.Printf("[Auth] Done\n")
+		c.authLog.Tracef("Done")
 		return nil
 	}
 
@@ -564,16 +672,11 @@ func (c *baseConnection) authenticatePSKProgress() error {
 
 // Caller should hold connection lock
 func (c *baseConnection) sendAuthSpake2NeedPsk() error {
-	at, err := c.getAuthInitiationToken()
-	if err != nil {
-		return err
-	}
-
 	msg := &msgAuthSpake2NeedPskDeprecated{
-		AuthInitiationToken: at,
+		AuthInitiationToken: c.nextAuthInitiationToken(),
 	}
 
-	err = writeMessage(msg, c.netConn)
+	err := c.writeMessage(msg, c.netConn)
 	if err != nil {
 		return err
 	}
@@ -587,18 +690,18 @@ func (c *baseConnection) sendAuthSpake2Handshake(publicValue []byte) error {
 	if c.authenticationRole == AuthenticationRolePresenter {
 		pskStatus = AuthSpake2PskStatusPskShown
 	}
-	// at, err := c.getAuthInitiationToken()
-	// if err != nil {
-	// 	return err
-	// }
+	token := msgAuthInitiationToken{}
+	if at := c.nextAuthInitiationToken(); at != "" {
+		token.Token = &at
+	}
 
 	msg := &msgAuthSpake2Handshake{
-		AuthInitiationToken: msgAuthInitiationToken{},
+		AuthInitiationToken: token,
 		PublicValue:         publicValue,
 		PskStatus:           pskStatus,
 	}
 
-	err := writeMessage(msg, c.netConn)
+	err := c.writeMessage(msg, c.netConn)
 	if err != nil {
 		return err
 	}
@@ -612,7 +715,7 @@ func (c *baseConnection) sendAuthSpake2Confirmation(payload []byte) error {
 		ConfirmationValue: payload,
 	}
 
-	err := writeMessage(msg, c.netConn)
+	err := c.writeMessage(msg, c.netConn)
 	if err != nil {
 		return err
 	}
@@ -628,7 +731,7 @@ func (c *baseConnection) sendAuthStatus() error {
 		Result: *authState.localResult,
 	}
 
-	err := writeMessage(msg, c.netConn)
+	err := c.writeMessage(msg, c.netConn)
 	if err != nil {
 		return err
 	}
@@ -656,7 +759,7 @@ func (c *baseConnection) handleAgentInfoRequest(msg *msgAgentInfoRequest) error
 			Locales: localInfo.Locales,
 		},
 	}
-	err := writeMessage(infoMsg, c.netConn)
+	err := c.writeMessage(infoMsg, c.netConn)
 	if err != nil {
 		return err
 	}
@@ -669,12 +772,12 @@ func (c *baseConnection) handleAgentInfoResponse(msg *msgAgentInfoResponse) erro
 	defer c.mu.Unlock()
 
 	if c.exchangeInfoState == nil {
-		fmt.Println("ignoring unsolicited AgentInfoResponse")
+		c.log.Warn("ignoring unsolicited AgentInfoResponse")
 		return nil
 	}
 
 	if c.exchangeInfoState.requestId != uint64(msg.RequestId) {
-		fmt.Println("ignoring AgentInfoResponse with wrong request ID")
+		c.log.Warn("ignoring AgentInfoResponse with wrong request ID")
 		return nil
 	}
 
@@ -692,7 +795,7 @@ func (c *baseConnection) handleAuthCapabilities(msg *msgAuthCapabilities) error
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	fmt.Printf("[Auth] handleAuthCapabilities: EaseOfInput=%d, MinBitsOfEntropy=%d\n", msg.PskEaseOfInput, msg.PskMinBitsOfEntropy)
+	c.authLog.Tracef("handleAuthCapabilities: EaseOfInput=%d, MinBitsOfEntropy=%d", msg.PskEaseOfInput, msg.PskMinBitsOfEntropy)
 
 	c.remoteAgent.setAuthenticationInfo(AgentAuthenticationInfo{
 		PSKConfig: PSKConfig{
@@ -706,18 +809,36 @@ func (c *baseConnection) handleAuthCapabilities(msg *msgAuthCapabilities) error
 	return nil
 }
 
+func (c *baseConnection) handleAuthKnownPeer(msg *msgAuthKnownPeer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.Fingerprint != string(c.localAgent.PeerID) {
+		c.authLog.Warn("ignoring auth-known-peer for different fingerprint")
+		return nil
+	}
+
+	c.remoteTrustsLocal = true
+
+	return nil
+}
+
 func (c *baseConnection) handleAuthSpake2NeedPsk(msg *msgAuthSpake2NeedPskDeprecated) error {
-	_ = msg
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	err := c.validateAuthInitiationToken(msg.AuthInitiationToken)
+	if err != nil {
+		return c.rejectAuthInitiationToken("spake2-need-psk", err)
+	}
+
 	if c.authenticationRole == AuthenticationRoleConsumer ||
 		c.authenticationState != nil {
-		fmt.Println("ignoring spake2-need-psk")
+		c.authLog.Warn("ignoring spake2-need-psk")
 		return nil
 	}
 
-	_, err := c.newAuthenticationState()
+	_, err = c.newAuthenticationState()
 	if err != nil {
 		return err
 	}
@@ -729,16 +850,19 @@ func (c *baseConnection) handleAuthSpake2Handshake(msg *msgAuthSpake2Handshake)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	fmt.Printf("[Auth] handleAuthSpake2Handshake: PublicValue=%d bytes, PskStatus=%d\n", len(msg.PublicValue), msg.PskStatus)
+	c.authLog.Tracef("handleAuthSpake2Handshake: PublicValue=%d bytes, PskStatus=%d", len(msg.PublicValue), msg.PskStatus)
 
-	// err := c.validateAuthInitiationToken(msg.AuthInitiationToken)
-	// if err != nil {
-	// 	return err
-	// }
+	token := ""
+	if msg.AuthInitiationToken.Token != nil {
+		token = *msg.AuthInitiationToken.Token
+	}
+	err := c.validateAuthInitiationToken(token)
+	if err != nil {
+		return c.rejectAuthInitiationToken("auth-spake2-handshake", err)
+	}
 
 	authState := c.authenticationState
 	if authState == nil {
-		var err error
 		authState, err = c.newAuthenticationState()
 		if err != nil {
 			return err
@@ -754,7 +878,7 @@ func (c *baseConnection) handleAuthSpake2Confirmation(msg *msgAuthSpake2Confirma
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	fmt.Printf("[Auth] handleAuthSpake2Confirmation: ConfirmationValue=%d bytes\n", len(msg.ConfirmationValue))
+	c.authLog.Tracef("handleAuthSpake2Confirmation: ConfirmationValue=%d bytes", len(msg.ConfirmationValue))
 
 	authState := c.authenticationState
 	if authState == nil {
@@ -770,7 +894,7 @@ func (c *baseConnection) handleAuthStatus(msg *msgAuthStatus) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	fmt.Printf("[Auth] handleAuthStatus: Result=%v\n", msg.Result)
+	c.authLog.Tracef("handleAuthStatus: Result=%v", msg.Result)
 
 	authState := c.authenticationState
 	if authState == nil {
@@ -795,16 +919,21 @@ func (c *baseConnection) handleAuthStatus(msg *msgAuthStatus) error {
 func (c *baseConnection) runNetwork() {
 	go func() {
 		for {
-			msg, err := readMessage(c.netConn)
+			msg, err := c.readMessage(c.netConn)
 			if err != nil {
-				fmt.Printf("network protocol: failed to read message: %v\n", err)
+				c.log.Debugf("network protocol: failed to read message: %v", err)
 				// c.closeWithError(fmt.Errorf("failed to read message: %v", err))
 				return
 			}
 
 			err = c.handleNetworkMessage(msg)
+			if errors.Is(err, errInvalidAuthInitiationToken) {
+				c.log.Warnf("network protocol: %v", err)
+				c.closeWithError(err)
+				return
+			}
 			if err != nil {
-				fmt.Printf("network protocol: failed to handle message: %v\n", err)
+				c.log.Warnf("network protocol: failed to handle message: %v", err)
 				// c.closeWithError(fmt.Errorf("failed to handle message: %v", err))
 				return
 			}
@@ -823,6 +952,9 @@ func (c *baseConnection) handleNetworkMessage(msg interface{}) (err error) {
 	case *msgAuthCapabilities:
 		err = c.handleAuthCapabilities(typedMsg)
 
+	case *msgAuthKnownPeer:
+		err = c.handleAuthKnownPeer(typedMsg)
+
 	case *msgAuthSpake2NeedPskDeprecated:
 		err = c.handleAuthSpake2NeedPsk(typedMsg)
 
@@ -836,7 +968,7 @@ func (c *baseConnection) handleNetworkMessage(msg interface{}) (err error) {
 		err = c.handleAuthStatus(typedMsg)
 
 	default:
-		fmt.Printf("baseConnection: unhandled message type: %T\n", typedMsg)
+		c.msgLog.Warnf("unhandled message type: %T", typedMsg)
 	}
 
 	if err != nil {
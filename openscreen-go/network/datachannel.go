@@ -2,14 +2,106 @@ package ospc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// defaultRTT is the round-trip time assumed if the connection doesn't
+// report one.
+const defaultRTT = 100 * time.Millisecond
+
+// dataChannelCloseTimeout bounds how long Close waits for messages sent on
+// streams of their own to be acknowledged. Unacknowledged messages are
+// abandoned afterwards.
+var dataChannelCloseTimeout = 5 * time.Second
+
+var ErrDuplicateChannelID = errors.New("duplicate data channel ID")
+var ErrDataChannelClosed = errors.New("data channel closed")
+
 // DataChannelParameters
 type DataChannelParameters struct {
 	Label    string
 	Protocol string
-	ID       uint64
+	// ID identifies the channel on the connection. If not set, an ID is
+	// assigned when opening the channel. The dialing agent assigns even
+	// IDs, the listening agent odd ones.
+	ID *uint64
+	// Negotiated channels are created by both agents with the same ID,
+	// without an in-band open. The dialing agent opens the stream of the
+	// channel, the listening agent binds it by ID.
+	Negotiated bool
+
+	// Unordered allows messages to be delivered out of order.
+	Unordered bool
+	// MaxRetransmits makes the channel partially reliable. QUIC doesn't
+	// expose retransmissions, so a message is abandoned if it isn't
+	// delivered within MaxRetransmits+1 round trips.
+	MaxRetransmits *uint64
+	// MaxPacketLifeTime makes the channel partially reliable. A message
+	// is abandoned if it isn't delivered within the lifetime.
+	MaxPacketLifeTime *time.Duration
+}
+
+// Validate checks if the reliability options can be combined.
+func (p DataChannelParameters) Validate() error {
+	if p.MaxRetransmits != nil && p.MaxPacketLifeTime != nil {
+		return errors.New("only one of MaxRetransmits and MaxPacketLifeTime can be set")
+	}
+	if p.partiallyReliable() && !p.Unordered {
+		return errors.New("partially reliable data channels must be unordered")
+	}
+	if p.Negotiated && p.ID == nil {
+		return errors.New("negotiated data channels need an ID")
+	}
+	return nil
+}
+
+func (p DataChannelParameters) partiallyReliable() bool {
+	return p.MaxRetransmits != nil || p.MaxPacketLifeTime != nil
+}
+
+// messageStreams reports if each message is sent on a stream of its own.
+// Otherwise, all messages are sent on the stream that opened the channel.
+func (p DataChannelParameters) messageStreams() bool {
+	return p.Unordered || p.partiallyReliable()
+}
+
+func newDataChannelOpenRequest(requestID uint64, params DataChannelParameters) *msgDataChannelOpenRequest {
+	msg := &msgDataChannelOpenRequest{
+		msgRequest: msgRequest{
+			RequestId: msgRequestId(requestID),
+		},
+		ChannelId:      *params.ID,
+		Label:          params.Label,
+		Protocol:       params.Protocol,
+		Unordered:      params.Unordered,
+		MaxRetransmits: params.MaxRetransmits,
+	}
+	if params.MaxPacketLifeTime != nil {
+		ms := uint64(params.MaxPacketLifeTime.Milliseconds())
+		msg.MaxPacketLifeTime = &ms
+	}
+	return msg
+}
+
+func dataChannelParametersFromRequest(msg *msgDataChannelOpenRequest) DataChannelParameters {
+	id := msg.ChannelId
+	params := DataChannelParameters{
+		Label:          msg.Label,
+		ID:             &id,
+		Protocol:       msg.Protocol,
+		Unordered:      msg.Unordered,
+		MaxRetransmits: msg.MaxRetransmits,
+	}
+	if msg.MaxPacketLifeTime != nil {
+		lifetime := time.Duration(*msg.MaxPacketLifeTime) * time.Millisecond
+		params.MaxPacketLifeTime = &lifetime
+	}
+	return params
 }
 
 // OpenDataChannel opens a data channel
@@ -23,34 +115,52 @@ func (c *Connection) AcceptDataChannel(ctx context.Context) (*DataChannel, error
 }
 
 func (c *baseConnection) OpenDataChannel(ctx context.Context, params DataChannelParameters) (*DataChannel, error) {
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	dc := newDataChannel(c, params)
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Registered up front, the remote agent can send messages as soon as
+	// it accepted the channel.
+	err = c.connectedState.addDataChannel(dc)
+	requestID := c.agentState.nextRequestID()
+	appConn := c.connectedState.appConn
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
 
-	msg := &msgDataChannelOpenRequest{
-		msgRequest: msgRequest{
-			RequestId: msgRequestId(c.agentState.nextRequestID()),
-		},
-		ChannelId: params.ID,
-		Label:     params.Label,
-		Protocol:  params.Protocol,
+	if params.Negotiated {
+		return c.openNegotiatedDataChannel(ctx, appConn, dc)
 	}
 
-	stream, err := c.connectedState.appConn.OpenStreamSync(ctx)
+	msg := newDataChannelOpenRequest(requestID, dc.DataChannelParameters)
+	res, err := c.request(ctx, appConn, requestID, msg)
 	if err != nil {
+		c.removeDataChannel(dc)
 		return nil, err
 	}
 
-	err = writeMessage(msg, stream)
+	openRes, ok := res.msg.(*msgDataChannelOpenResponse)
+	if !ok {
+		c.removeDataChannel(dc)
+		_ = res.stream.Close()
+		return nil, fmt.Errorf("unexpected response: %T", res.msg)
+	}
+	err = resultError(openRes.Result)
 	if err != nil {
+		c.removeDataChannel(dc)
+		_ = res.stream.Close()
 		return nil, err
 	}
 
-	// TODO: await data-exchange-start-response
+	dc.stream = res.stream
+	dc.run()
 
-	return &DataChannel{
-		DataChannelParameters: params,
-		stream:                stream,
-	}, nil
+	return dc, nil
 }
 
 func (c *baseConnection) AcceptDataChannel(ctx context.Context) (*DataChannel, error) {
@@ -69,9 +179,179 @@ func (c *baseConnection) AcceptDataChannel(ctx context.Context) (*DataChannel, e
 	}
 }
 
+// openNegotiatedDataChannel binds a negotiated channel to its stream.
+func (c *baseConnection) openNegotiatedDataChannel(ctx context.Context, appConn ApplicationConnection, dc *DataChannel) (*DataChannel, error) {
+	if c.agentRole == AgentRoleClient {
+		stream, err := appConn.OpenStreamSync(ctx)
+		if err != nil {
+			c.removeDataChannel(dc)
+			return nil, err
+		}
+		err = c.writeMessage(&msgDataChannelNegotiated{
+			ChannelId: *dc.ID,
+		}, stream)
+		if err != nil {
+			_ = stream.Reset()
+			c.removeDataChannel(dc)
+			return nil, err
+		}
+
+		dc.stream = stream
+		dc.run()
+		return dc, nil
+	}
+
+	c.mu.Lock()
+	close := c.close
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		c.removeDataChannel(dc)
+		return nil, ctx.Err()
+	case <-close:
+		c.removeDataChannel(dc)
+		return nil, c.err()
+	case stream := <-dc.negotiated:
+		dc.stream = stream
+		dc.run()
+		return dc, nil
+	}
+}
+
+func (c *baseConnection) removeDataChannel(dc *DataChannel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if dc.ID != nil && c.connectedState.dataChannels[*dc.ID] == dc {
+		delete(c.connectedState.dataChannels, *dc.ID)
+	}
+}
+
+// addDataChannel registers a channel by its ID. If the channel has no ID,
+// the next free one of the agent's parity is assigned. Caller should hold
+// the connection lock.
+func (s *connectedState) addDataChannel(dc *DataChannel) error {
+	if dc.ID == nil {
+		id := s.nextChannelID
+		for s.channelIDInUse(id) {
+			id += 2
+		}
+		s.nextChannelID = id + 2
+		dc.ID = &id
+	} else if _, open := s.dataChannels[*dc.ID]; open {
+		return fmt.Errorf("%w: %d", ErrDuplicateChannelID, *dc.ID)
+	}
+
+	// The listening agent may have received the stream of a negotiated
+	// channel already.
+	stream, pending := s.negotiatedStreams[*dc.ID]
+	if pending {
+		if !dc.Negotiated {
+			return fmt.Errorf("%w: %d", ErrDuplicateChannelID, *dc.ID)
+		}
+		delete(s.negotiatedStreams, *dc.ID)
+		dc.negotiated <- stream
+	}
+	s.dataChannels[*dc.ID] = dc
+	return nil
+}
+
+// Caller should hold the connection lock.
+func (s *connectedState) channelIDInUse(id uint64) bool {
+	_, open := s.dataChannels[id]
+	_, pending := s.negotiatedStreams[id]
+	return open || pending
+}
+
+// DataChannelPolicy decides whether a data channel opened by the remote
+// agent is accepted. Returning an error refuses the data channel. The
+// remote agent receives a permanent-error result, unless the error is a
+// ResultError.
+type DataChannelPolicy func(remoteAgent *Agent, params DataChannelParameters) error
+
 type DataChannel struct {
 	DataChannelParameters
+	conn   *baseConnection
 	stream ApplicationStream
+
+	// The stream of a negotiated channel, handed over by the dialing
+	// agent.
+	negotiated chan ApplicationStream
+
+	// Unordered and partially reliable channels only use the stream to
+	// open and close the channel. Messages are received on streams of
+	// their own, tagged with the ID of the channel.
+	messages chan *msgDataChannelMessage
+
+	done    chan struct{} // closed when the stream is closed by the remote agent
+	doneErr error
+
+	mu sync.Mutex
+	// Streams of messages that aren't acknowledged yet. No messages are
+	// sent once closing is set.
+	inflight   map[ApplicationStream]struct{}
+	inflightWG sync.WaitGroup
+	closing    bool
+	// The ID is released once both agents closed the channel.
+	localClosed  bool
+	remoteClosed bool
+}
+
+func newDataChannel(c *baseConnection, params DataChannelParameters) *DataChannel {
+	dc := &DataChannel{
+		DataChannelParameters: params,
+		conn:                  c,
+		negotiated:            make(chan ApplicationStream, 1),
+		done:                  make(chan struct{}),
+		inflight:              make(map[ApplicationStream]struct{}),
+	}
+	if params.messageStreams() {
+		dc.messages = make(chan *msgDataChannelMessage)
+	}
+	return dc
+}
+
+// setClosed records that one of the agents closed the channel.
+func (c *DataChannel) setClosed(local bool) {
+	c.mu.Lock()
+	if local {
+		c.localClosed = true
+	} else {
+		c.remoteClosed = true
+	}
+	closed := c.localClosed && c.remoteClosed
+	c.mu.Unlock()
+
+	if closed {
+		c.conn.removeDataChannel(c)
+	}
+}
+
+// run watches the stream of a channel that receives its messages on
+// streams of their own.
+func (c *DataChannel) run() {
+	if !c.messageStreams() {
+		return
+	}
+
+	go func() {
+		var err error
+		for err == nil {
+			_, err = c.conn.readMessage(c.stream)
+		}
+		c.doneErr = err
+		close(c.done)
+		c.setClosed(false)
+	}()
+}
+
+// deliver hands a message received on a stream of its own to the reader.
+func (c *DataChannel) deliver(msg *msgDataChannelMessage) {
+	select {
+	case c.messages <- msg:
+	case <-c.done:
+	}
 }
 
 // SendMessage
@@ -81,12 +361,140 @@ func (c *DataChannel) SendMessage(payload []byte) error {
 
 // SendMessageWithEncoding
 func (c *DataChannel) SendMessageWithEncoding(payload []byte, enc DataEncoding) error {
+	if c.messageStreams() {
+		return c.sendMessageStream(payload, enc)
+	}
+
 	msg := &msgDataFrame{
 		EncodingId: uint64(enc),
 		Payload:    payload,
 	}
 
-	return writeMessage(msg, c.stream)
+	return c.conn.writeMessage(msg, c.stream)
+}
+
+// sendMessageStream sends a message on a stream of its own. Partially
+// reliable messages are abandoned by resetting the stream once their
+// lifetime passed.
+func (c *DataChannel) sendMessageStream(payload []byte, enc DataEncoding) error {
+	c.conn.mu.Lock()
+	appConn := c.conn.connectedState.appConn
+	c.conn.mu.Unlock()
+
+	stream, err := appConn.OpenStreamSync(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if !c.trackMessage(stream) {
+		_ = stream.Reset()
+		return ErrDataChannelClosed
+	}
+
+	var timer *time.Timer
+	var abandoned atomic.Bool
+	if lifetime, ok := c.lifetime(); ok {
+		timer = time.AfterFunc(lifetime, func() {
+			abandoned.Store(true)
+			_ = stream.Reset()
+		})
+	}
+
+	err = c.conn.writeMessage(&msgDataChannelMessage{
+		ChannelId:  *c.ID,
+		EncodingId: uint64(enc),
+		Payload:    payload,
+	}, stream)
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		c.untrackMessage(stream)
+		if abandoned.Load() {
+			return nil
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		_ = stream.Reset()
+		return err
+	}
+
+	go func() {
+		defer c.untrackMessage(stream)
+
+		// The remote agent acknowledges the message by closing the
+		// stream.
+		_, _ = io.Copy(io.Discard, stream)
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	return nil
+}
+
+// trackMessage registers the stream of a message until it's acknowledged.
+// It returns false if the channel is closing.
+func (c *DataChannel) trackMessage(stream ApplicationStream) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing {
+		return false
+	}
+	c.inflight[stream] = struct{}{}
+	c.inflightWG.Add(1)
+	return true
+}
+
+func (c *DataChannel) untrackMessage(stream ApplicationStream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.inflight[stream]; ok {
+		delete(c.inflight, stream)
+		c.inflightWG.Done()
+	}
+}
+
+// waitInflight waits for the messages sent on streams of their own to be
+// acknowledged. After dataChannelCloseTimeout, the remaining messages are
+// abandoned so a remote agent that stopped reading can't block the close.
+// Caller must have set closing.
+func (c *DataChannel) waitInflight() {
+	done := make(chan struct{})
+	go func() {
+		c.inflightWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dataChannelCloseTimeout):
+		c.mu.Lock()
+		streams := make([]ApplicationStream, 0, len(c.inflight))
+		for s := range c.inflight {
+			streams = append(streams, s)
+		}
+		c.mu.Unlock()
+
+		for _, s := range streams {
+			_ = s.Reset()
+		}
+	}
+}
+
+// lifetime returns how long a message is retransmitted on a partially
+// reliable channel.
+func (c *DataChannel) lifetime() (time.Duration, bool) {
+	switch {
+	case c.MaxPacketLifeTime != nil:
+		return *c.MaxPacketLifeTime, true
+	case c.MaxRetransmits != nil:
+		return time.Duration(*c.MaxRetransmits+1) * c.conn.rtt(), true
+	default:
+		return 0, false
+	}
 }
 
 // ReceiveMessage
@@ -97,8 +505,18 @@ func (c *DataChannel) ReceiveMessage() ([]byte, error) {
 
 // ReceiveMessageWithEncoding
 func (c *DataChannel) ReceiveMessageWithEncoding() ([]byte, DataEncoding, error) {
-	msg, err := readMessage(c.stream)
+	if c.messageStreams() {
+		select {
+		case msg := <-c.messages:
+			return msg.Payload, DataEncoding(msg.EncodingId), nil
+		case <-c.done:
+			return nil, 0, c.doneErr
+		}
+	}
+
+	msg, err := c.conn.readMessage(c.stream)
 	if err != nil {
+		c.setClosed(false)
 		return nil, 0, err
 	}
 
@@ -118,20 +536,13 @@ func (c *DataChannel) Read(p []byte) (int, error) {
 
 // ReadDataChannel reads a packet of len(p) bytes
 func (c *DataChannel) ReadDataChannel(p []byte) (int, DataEncoding, error) {
-	msg, err := readMessage(c.stream)
+	payload, enc, err := c.ReceiveMessageWithEncoding()
 	if err != nil {
 		return 0, 0, err
 	}
 
-	dataFrame, ok := msg.(msgDataFrame)
-	if !ok {
-		return 0, 0, fmt.Errorf("unexpected message type")
-	}
-
-	payload := dataFrame.Payload
-
 	n := copy(p, payload)
-	return int(n), DataEncoding(dataFrame.EncodingId), nil
+	return n, enc, nil
 }
 
 // Write writes len(p) bytes from p as binary data
@@ -141,12 +552,7 @@ func (c *DataChannel) Write(p []byte) (n int, err error) {
 
 // WriteDataChannel writes len(p) bytes from p
 func (c *DataChannel) WriteDataChannel(p []byte, enc DataEncoding) (n int, err error) {
-	msg := &msgDataFrame{
-		EncodingId: uint64(enc),
-		Payload:    p,
-	}
-
-	err = writeMessage(msg, c.stream)
+	err = c.SendMessageWithEncoding(p, enc)
 	if err != nil {
 		return 0, err
 	}
@@ -154,7 +560,15 @@ func (c *DataChannel) WriteDataChannel(p []byte, enc DataEncoding) (n int, err e
 	return len(p), nil
 }
 
-// Close closes the DataChannel and the underlying Quic stream.
+// Close closes the DataChannel and the underlying Quic stream. Messages
+// sent on streams of their own are acknowledged or abandoned first, so
+// they reach the remote agent before the close.
 func (c *DataChannel) Close() error {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+
+	c.waitInflight()
+	c.setClosed(true)
 	return c.stream.Close()
 }
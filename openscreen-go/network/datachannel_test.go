@@ -0,0 +1,332 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUnorderedDataChannel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	// Partial reliability requires unordered delivery.
+	retransmits := uint64(0)
+	_, err := dConn.OpenDataChannel(ctx, DataChannelParameters{
+		Label:          "ordered",
+		MaxRetransmits: &retransmits,
+	})
+	if err == nil {
+		t.Fatal("expected error opening an ordered partially reliable channel")
+	}
+
+	lifetime := 5 * time.Second
+	dc, err := dConn.OpenDataChannel(ctx, DataChannelParameters{
+		Label:             "unordered",
+		Unordered:         true,
+		MaxPacketLifeTime: &lifetime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := aConn.AcceptDataChannel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both ends agree on the mode.
+	if !remote.Unordered || remote.MaxPacketLifeTime == nil || *remote.MaxPacketLifeTime != lifetime {
+		t.Fatalf("unexpected remote parameters: %+v", remote.DataChannelParameters)
+	}
+
+	// Every message is delivered, in any order.
+	sent := []string{"a", "b", "c"}
+	for _, m := range sent {
+		err = dc.SendMessageWithEncoding([]byte(m), DataEncodingString)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var received []string
+	for range sent {
+		p, enc, err := remote.ReceiveMessageWithEncoding()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc != DataEncodingString {
+			t.Fatalf("unexpected encoding: %d", enc)
+		}
+		received = append(received, string(p))
+	}
+	sort.Strings(received)
+	for i := range sent {
+		if received[i] != sent[i] {
+			t.Fatalf("unexpected messages: %v", received)
+		}
+	}
+
+	// And in the other direction.
+	err = remote.SendMessage([]byte("reply"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := dc.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "reply" {
+		t.Fatalf("unexpected message: %s", p)
+	}
+
+	// Closing the channel reaches the remote agent.
+	err = dc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = remote.ReceiveMessage()
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got: %v", err)
+	}
+}
+
+func TestDataChannelCloseUnacknowledged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	timeout := dataChannelCloseTimeout
+	dataChannelCloseTimeout = 100 * time.Millisecond
+	defer func() { dataChannelCloseTimeout = timeout }()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	dc, err := dConn.OpenDataChannel(ctx, DataChannelParameters{
+		Label:     "unordered",
+		Unordered: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = aConn.AcceptDataChannel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote agent doesn't read, so the message isn't acknowledged.
+	err = dc.SendMessage([]byte("unread"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- dc.Close()
+	}()
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-ctx.Done():
+		t.Fatal("close blocked on an unacknowledged message")
+	}
+
+	err = dc.SendMessage([]byte("late"))
+	if !errors.Is(err, ErrDataChannelClosed) {
+		t.Fatalf("expected ErrDataChannelClosed, got: %v", err)
+	}
+}
+
+func TestDataChannelIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	// The dialing agent is assigned even IDs, the listening agent odd ones.
+	dc, err := dConn.OpenDataChannel(ctx, DataChannelParameters{Label: "dialer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := aConn.AcceptDataChannel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dc.ID%2 != 0 || *remote.ID != *dc.ID {
+		t.Fatalf("unexpected IDs: %d, %d", *dc.ID, *remote.ID)
+	}
+	adc, err := aConn.OpenDataChannel(ctx, DataChannelParameters{Label: "listener"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *adc.ID%2 != 1 {
+		t.Fatalf("unexpected ID: %d", *adc.ID)
+	}
+
+	// IDs can't be reused.
+	id := *dc.ID
+	_, err = dConn.OpenDataChannel(ctx, DataChannelParameters{
+		Label: "duplicate",
+		ID:    &id,
+	})
+	if !errors.Is(err, ErrDuplicateChannelID) {
+		t.Fatalf("expected duplicate ID error, got: %v", err)
+	}
+}
+
+func TestNegotiatedDataChannel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	// Negotiated channels need an ID.
+	_, err := dConn.OpenDataChannel(ctx, DataChannelParameters{
+		Label:      "no-id",
+		Negotiated: true,
+	})
+	if err == nil {
+		t.Fatal("expected error opening a negotiated channel without ID")
+	}
+
+	openNegotiated := func(conn *Connection, id uint64) (*DataChannel, error) {
+		return conn.OpenDataChannel(ctx, DataChannelParameters{
+			Label:      "negotiated",
+			ID:         &id,
+			Negotiated: true,
+		})
+	}
+
+	// The listening agent creates the channel after the dialing agent
+	// and before it.
+	for _, listenerFirst := range []bool{false, true} {
+		id := uint64(10)
+		if listenerFirst {
+			id = 11
+		}
+
+		var dc, remote *DataChannel
+		var remoteErr error
+		done := make(chan struct{})
+		openRemote := func() {
+			defer close(done)
+			remote, remoteErr = openNegotiated(aConn, id)
+		}
+		if listenerFirst {
+			go openRemote()
+			// Give the listening agent time to register the channel.
+			time.Sleep(50 * time.Millisecond)
+		}
+		dc, err = openNegotiated(dConn, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !listenerFirst {
+			go openRemote()
+		}
+		<-done
+		if remoteErr != nil {
+			t.Fatal(remoteErr)
+		}
+
+		err = dc.SendMessage([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := remote.ReceiveMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != "ping" {
+			t.Fatalf("unexpected message: %s", p)
+		}
+		err = remote.SendMessage([]byte("pong"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err = dc.ReceiveMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != "pong" {
+			t.Fatalf("unexpected message: %s", p)
+		}
+	}
+
+	// Negotiated channels aren't announced.
+	acceptCtx, acceptCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer acceptCancel()
+	_, err = aConn.AcceptDataChannel(acceptCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected no announced channel, got: %v", err)
+	}
+}
+
+func TestNegotiatedDataChannelBacklog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	pending := func() int {
+		aConn.base.mu.Lock()
+		defer aConn.base.mu.Unlock()
+		return len(aConn.base.connectedState.negotiatedStreams)
+	}
+
+	// The listening agent keeps a limited number of streams for
+	// negotiated channels it didn't create.
+	for i := 0; i <= negotiatedStreamBacklog; i++ {
+		id := uint64(100 + 2*i)
+		_, err := dConn.OpenDataChannel(ctx, DataChannelParameters{
+			Label:      "negotiated",
+			ID:         &id,
+			Negotiated: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pending() < negotiatedStreamBacklog {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("streams not received: %d", pending())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pending() != negotiatedStreamBacklog {
+		t.Fatalf("unexpected pending streams: %d", pending())
+	}
+
+	// A channel waiting for its stream is removed when the connection
+	// closes, as are the pending streams.
+	id := uint64(1001)
+	opened := make(chan error, 1)
+	go func() {
+		_, err := aConn.OpenDataChannel(ctx, DataChannelParameters{
+			Label:      "negotiated",
+			ID:         &id,
+			Negotiated: true,
+		})
+		opened <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = aConn.Close()
+	if err := <-opened; err == nil {
+		t.Fatal("expected error opening a channel on a closed connection")
+	}
+	if pending() != 0 {
+		t.Fatalf("unexpected pending streams: %d", pending())
+	}
+	aConn.base.mu.Lock()
+	_, ok := aConn.base.connectedState.dataChannels[id]
+	aConn.base.mu.Unlock()
+	if ok {
+		t.Fatal("data channel not removed")
+	}
+}
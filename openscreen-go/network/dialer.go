@@ -9,35 +9,80 @@ import (
 	"fmt"
 	"math/big"
 
-	mdns "github.com/grandcat/zeroconf"
+	"github.com/quic-go/quic-go"
 )
 
 // Dial opens a connection to the remote agent.
 func (ra DiscoveredAgent) Dial(ctx context.Context, transportType AgentTransport, la *Agent) (*UnauthenticatedConnection, error) {
-	snBase64, err := ra.TXT.GetOne("sn")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get sn record: %v", err)
-	}
 	fp, err := ra.TXT.GetOne("fp")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get fp record: %v", err)
 	}
 
-	// Decode URL-safe base64 serial number to get expected certificate serial number.
-	// See: https://github.com/w3c/openscreenprotocol/issues/365
-	expectedSNBytes, err := base64.RawURLEncoding.DecodeString(snBase64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode sn: %v", err)
+	// The certificate serial number and hostname can only be checked if
+	// the agent advertised its sn, e.g., not for statically configured
+	// agents. The fingerprint is always pinned.
+	var expectedSN *big.Int
+	var cn string
+	snBase64, err := ra.TXT.GetOne("sn")
+	if err == nil {
+		// Decode URL-safe base64 serial number to get expected certificate serial number.
+		// See: https://github.com/w3c/openscreenprotocol/issues/365
+		expectedSNBytes, err := base64.RawURLEncoding.DecodeString(snBase64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode sn: %v", err)
+		}
+		expectedSN = new(big.Int).SetBytes(expectedSNBytes)
+
+		// Build OpenScreen-compliant hostname from discovered agent info
+		cn = buildAgentHostname(snBase64, ra.Nickname(), MdnsDomain)
+	}
+
+	tlsConfig := newDialTLSConfig(la, fp, expectedSN, cn)
+	addr := fmt.Sprintf("%s:%d", ra.instance.dialHost(), ra.instance.Port)
+
+	return dial(ctx, addr, tlsConfig, transportType, la, ra.AuthInitiationToken)
+}
+
+// DialOption configures DialAddr.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	authInitiationToken string
+}
+
+// WithAuthInitiationToken sets the at value of the remote agent. It's
+// required to authenticate with a PSK but may be omitted for mutually
+// trusted agents.
+func WithAuthInitiationToken(token string) DialOption {
+	return func(o *dialOptions) {
+		o.authInitiationToken = token
+	}
+}
+
+// DialAddr opens a connection to the agent at addr without prior discovery,
+// e.g., using an address and fingerprint from an earlier session or a QR
+// code. The certificate of the remote agent is pinned to the fingerprint.
+func DialAddr(ctx context.Context, addr string, fingerprint string, transportType AgentTransport, la *Agent, opts ...DialOption) (*UnauthenticatedConnection, error) {
+	if fingerprint == "" {
+		return nil, errors.New("no fingerprint")
+	}
+
+	var o dialOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
-	expectedSN := new(big.Int).SetBytes(expectedSNBytes)
 
-	// Build OpenScreen-compliant hostname from discovered agent info
-	// Unescape DNS-SD encoding (e.g., "Test\ Receiver" -> "Test Receiver")
-	instanceName := unescapeDNSSD(ra.info.ServiceRecord.Instance)
-	domain := MdnsDomain
-	cn := buildAgentHostname(snBase64, instanceName, domain)
+	tlsConfig := newDialTLSConfig(la, fingerprint, nil, "")
+
+	return dial(ctx, addr, tlsConfig, transportType, la, o.authInitiationToken)
+}
 
-	tlsConfig := &tls.Config{
+// newDialTLSConfig creates the TLS config to dial a remote agent. The peer
+// certificate must match the fingerprint. The serial number and hostname
+// are only verified if expectedSN is set.
+func newDialTLSConfig(la *Agent, fingerprint string, expectedSN *big.Int, cn string) *tls.Config {
+	return &tls.Config{
 		MinVersion:         tls.VersionTLS13, // OpenScreen spec requires TLS 1.3
 		MaxVersion:         tls.VersionTLS13,
 		InsecureSkipVerify: true, // Manual verification in VerifyConnection
@@ -49,12 +94,15 @@ func (ra DiscoveredAgent) Dial(ctx context.Context, transportType AgentTransport
 				return errors.New("didn't expect cert chain")
 			}
 			peerCert := cs.PeerCertificates[0]
-			
+			if expectedSN == nil {
+				return nil
+			}
+
 			// Verify certificate serial number matches advertised value
 			if peerCert.SerialNumber.Cmp(expectedSN) != 0 {
 				return fmt.Errorf("certificate serial number mismatch: expected %s, got %s", expectedSN.String(), peerCert.SerialNumber.String())
 			}
-			
+
 			roots := x509.NewCertPool()
 			roots.AddCert(peerCert)
 
@@ -83,12 +131,15 @@ func (ra DiscoveredAgent) Dial(ctx context.Context, transportType AgentTransport
 				certs = append(certs, cert)
 			}
 
-			return validateFingerprint(fp, certs)
+			return validateFingerprint(fingerprint, certs)
 		},
 	}
-	addr := fmt.Sprintf("%s:%d", getMdnsHost(ra.info), ra.info.Port)
+}
 
-	t, err := NewNetworkTransport(transportType)
+// dial connects to addr and exchanges the agent info. The authInitiationToken
+// is the at value advertised by the remote agent, if known.
+func dial(ctx context.Context, addr string, tlsConfig *tls.Config, transportType AgentTransport, la *Agent, authInitiationToken string) (*UnauthenticatedConnection, error) {
+	t, err := NewNetworkTransport(transportType, la.loggerFactory)
 	if err != nil {
 		return nil, err
 	}
@@ -101,44 +152,63 @@ func (ra DiscoveredAgent) Dial(ctx context.Context, transportType AgentTransport
 	if err != nil {
 		return nil, err
 	}
+	remoteAgent.setAuthInitiationToken(authInitiationToken)
 	bConn := newBaseConnection(
 		nc,
 		la,
 		remoteAgent,
 		AgentRoleClient,
 	)
+	bConn.dialAddr = addr
+	bConn.transportType = transportType
 
 	bConn.runNetwork()
 
-	pendingCh := make(chan exchangeInfoResult)
-	err = bConn.exchangeInfo(ctx, pendingCh)
+	pendingCh, err := bConn.exchangeInfo(ctx)
 	if err != nil {
+		bConn.Close()
 		return nil, err
 	}
 
 	select {
 	case <-ctx.Done():
+		bConn.Close()
 		return nil, ctx.Err()
 	case res := <-pendingCh: // TODO: handle meta-discovery failure.
 		if res.err != nil {
+			bConn.Close()
 			return nil, res.err
 		}
 	}
 
-	return &UnauthenticatedConnection{
-		base: bConn,
-	}, nil
+	uConn, err := newUnauthenticatedConnection(bConn)
+	if err != nil {
+		bConn.Close()
+		return nil, err
+	}
+
+	return uConn, nil
 }
 
-func getMdnsHost(entry *mdns.ServiceEntry) string {
-	for _, ipv6 := range entry.AddrIPv6 {
-		// log.Printf("Choosing IPv6 address [%s]\n", ipv6)
-		return fmt.Sprintf("[%s]", ipv6)
+// DialApplication opens a dedicated QUIC connection to the remote agent for
+// an application protocol, see Listener.ListenApplication. The remote
+// certificate is pinned to the one verified for this connection. Only the
+// agent that dialed the connection can dial the application protocol and
+// only over QUIC.
+func (c *Connection) DialApplication(ctx context.Context, alpn string) (quic.Connection, error) {
+	return c.base.DialApplication(ctx, alpn)
+}
+
+func (c *baseConnection) DialApplication(ctx context.Context, alpn string) (quic.Connection, error) {
+	if c.agentRole != AgentRoleClient {
+		return nil, errors.New("only the dialing agent can dial an application")
 	}
-	for _, ipv4 := range entry.AddrIPv4 {
-		// log.Printf("Choosing IPv4 address %s\n", ipv4)
-		return ipv4.String()
+	if c.transportType != AgentTransportQUIC {
+		return nil, fmt.Errorf("transport doesn't support applications: %d", c.transportType)
 	}
-	// log.Printf("No IP address found. Falling back to hostname %s\n", entry.HostName)
-	return entry.HostName
+
+	tlsConfig := newDialTLSConfig(c.localAgent, string(c.remoteAgent.PeerID), nil, "")
+	tlsConfig.NextProtos = []string{alpn}
+
+	return quic.DialAddr(ctx, c.dialAddr, tlsConfig, newQuicConfig())
 }
@@ -0,0 +1,415 @@
+package ospc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestDialAddr(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportQUIC, &ListenerConfig{
+		Addr: "127.0.0.1:0",
+	})
+	l.WithDiscoveryProvider(NewMemoryDiscovery())
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	addr := l.Addr().String()
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uConn, err := DialAddr(ctx, addr, string(listenAgent.PeerID), AgentTransportQUIC, dialAgent, WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uConn.Close()
+
+	if uConn.RemoteAgent().PeerID != listenAgent.PeerID {
+		t.Fatalf("unexpected remote agent: %s", uConn.RemoteAgent().PeerID)
+	}
+	if uConn.RemoteAgent().Info().DisplayName != "Listener" {
+		t.Fatalf("unexpected display name: %s", uConn.RemoteAgent().Info().DisplayName)
+	}
+
+	// The certificate is pinned to the fingerprint.
+	otherAgent, err := NewAgent(NewAgentConfig("Other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DialAddr(ctx, addr, string(otherAgent.PeerID), AgentTransportQUIC, dialAgent)
+	if err == nil {
+		t.Fatal("expected fingerprint mismatch")
+	}
+}
+
+func TestDialAddrAuthInitiationToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportLoopback, nil)
+	l.WithDiscoveryProvider(NewMemoryDiscovery())
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The listening agent rejects authentication without its at.
+	for _, token := range []string{"", "wrong"} {
+		dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		uConn, err := DialAddr(ctx, l.Addr().String(), string(listenAgent.PeerID), AgentTransportLoopback, dialAgent, WithAuthInitiationToken(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lConn, err := l.Accept(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lErr := make(chan error, 1)
+		go func() {
+			_, err := lConn.AcceptAuthenticate(ctx)
+			if err == nil {
+				_, err = lConn.AuthenticatePSK(ctx, []byte("0124"))
+			}
+			lErr <- err
+		}()
+
+		dCtx, dCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		_, err = uConn.AuthenticatePSK(dCtx, []byte("0124"))
+		dCancel()
+		if err == nil {
+			t.Fatalf("token %q: expected dialer authentication to fail", token)
+		}
+		err = <-lErr
+		if !errors.Is(err, errInvalidAuthInitiationToken) {
+			t.Fatalf("token %q: unexpected listener error: %v", token, err)
+		}
+		_ = uConn.Close()
+	}
+}
+
+func TestDialApplication(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const alpn = "test-app"
+
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportQUIC, &ListenerConfig{
+		Addr: "127.0.0.1:0",
+	})
+	l.WithDiscoveryProvider(NewMemoryDiscovery())
+	appListener := l.ListenApplication(alpn, nil)
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	addr := l.Addr().String()
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uConn, err := DialAddr(ctx, addr, string(listenAgent.PeerID), AgentTransportQUIC, dialAgent, WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lConn, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dConn, aConn := authenticatePSK(ctx, t, uConn, lConn)
+	defer dConn.Close()
+	defer aConn.Close()
+
+	// Only the dialing agent can open a dedicated connection.
+	_, err = aConn.DialApplication(ctx, alpn)
+	if err == nil {
+		t.Fatal("expected error dialing from the listening agent")
+	}
+
+	qConn, err := dConn.DialApplication(ctx, alpn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := NewDedicatedWebTransport(qConn)
+	defer wt.CloseWithError(0, "done")
+
+	aqConn, err := appListener.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awt := NewDedicatedWebTransport(aqConn)
+	peerID, err := awt.RemotePeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peerID != dialAgent.PeerID {
+		t.Fatalf("unexpected remote peer: %s", peerID)
+	}
+
+	s, err := wt.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, err := awt.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(as, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected stream data: %s", buf)
+	}
+
+	// Unidirectional streams map to QUIC unidirectional streams.
+	us, err := wt.OpenUniStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = us.Write([]byte("uni"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = us.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aus, err := awt.AcceptUniStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(aus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "uni" {
+		t.Fatalf("unexpected stream data: %s", data)
+	}
+	if aus.StreamID() != us.StreamID() {
+		t.Fatalf("stream ID mismatch: %d != %d", aus.StreamID(), us.StreamID())
+	}
+
+	// Datagrams map to QUIC DATAGRAM frames.
+	if wt.MaxDatagramSize() != quicMaxDatagramSize {
+		t.Fatalf("unexpected max datagram size: %d", wt.MaxDatagramSize())
+	}
+	err = wt.SendDatagram([]byte("datagram"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := awt.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "datagram" {
+		t.Fatalf("unexpected datagram: %s", p)
+	}
+
+	// The OSP connection keeps working next to the dedicated one.
+	dc, err := dConn.OpenDataChannel(ctx, DataChannelParameters{Label: "osp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	adc, err := aConn.AcceptDataChannel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dc.SendMessage([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := adc.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "ping" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+	err = adc.SendMessage([]byte("pong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = dc.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "pong" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	// Stats are collected from the QUIC connection.
+	stats, err := wt.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PacketsSent == 0 || stats.BytesReceived == 0 || stats.SmoothedRTT == 0 || stats.CongestionWindow == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	pwt, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err = pwt.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PacketsSent == 0 {
+		t.Fatalf("unexpected pooled stats: %+v", stats)
+	}
+	_ = pwt.CloseWithError(0, "done")
+
+	// The close info reaches the remote agent.
+	err = wt.CloseWithError(42, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-awt.Context().Done():
+	case <-ctx.Done():
+		t.Fatal("remote transport not closed")
+	}
+	var sessErr *SessionError
+	if !errors.As(context.Cause(awt.Context()), &sessErr) {
+		t.Fatalf("unexpected cause: %v", context.Cause(awt.Context()))
+	}
+	if !sessErr.Remote || sessErr.Code != 42 || sessErr.Reason != "bye" {
+		t.Fatalf("unexpected close info: %+v", sessErr)
+	}
+
+	// Untrusted agents are rejected.
+	otherAgent, err := NewAgent(NewAgentConfig("Other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := newDialTLSConfig(otherAgent, string(listenAgent.PeerID), nil, "")
+	tlsConfig.NextProtos = []string{alpn}
+	// With TLS 1.3 the client certificate is verified after the client
+	// completed the handshake, the rejection closes the connection.
+	untrusted, err := quic.DialAddr(ctx, addr, tlsConfig, nil)
+	if err == nil {
+		_, err = untrusted.AcceptStream(ctx)
+	}
+	if err == nil || ctx.Err() != nil {
+		t.Fatal("expected untrusted agent to be rejected")
+	}
+}
+
+func TestExchangeInfoClosed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The remote agent completes the handshake but never answers.
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := NewLoopbackTransport()
+	nl, err := transport.ListenAddr("127.0.0.1:0", &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		MaxVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{*listenAgent.Certificate},
+		NextProtos:   []string{ALPN_OSP},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nl.Close()
+	go func() {
+		var conns []NetworkConnection
+		for {
+			nc, err := nl.Accept(ctx)
+			if err != nil {
+				for _, nc := range conns {
+					_ = nc.Close()
+				}
+				return
+			}
+			conns = append(conns, nc)
+		}
+	}()
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A dial is cancelled by its context.
+	dialCtx, dialCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer dialCancel()
+	_, err = DialAddr(dialCtx, nl.Addr().String(), string(listenAgent.PeerID), AgentTransportLoopback, dialAgent)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+
+	// The result of an exchange is kept if the connection closes before
+	// it's waited on.
+	tlsConfig := newDialTLSConfig(dialAgent, string(listenAgent.PeerID), nil, "")
+	nc, err := transport.DialAddr(ctx, nl.Addr().String(), tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteAgent, err := dialAgent.NewRemoteAgent(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bConn := newBaseConnection(nc, dialAgent, remoteAgent, AgentRoleClient)
+	bConn.runNetwork()
+	done, err := bConn.exchangeInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = bConn.Close()
+
+	select {
+	case res := <-done:
+		if !errors.Is(res.err, ErrConnectionClosed) {
+			t.Fatalf("expected ErrConnectionClosed, got: %v", res.err)
+		}
+	case <-ctx.Done():
+		t.Fatal("exchange result dropped")
+	}
+}
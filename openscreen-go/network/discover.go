@@ -4,19 +4,55 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 
-	mdns "github.com/grandcat/zeroconf"
+	"github.com/pion/logging"
 )
 
-// unescapeDNSSD removes DNS-SD escaping from instance names.
-// The zeroconf library returns names with escaped special chars (e.g., "Test\ Receiver").
-func unescapeDNSSD(s string) string {
-	return strings.ReplaceAll(s, `\ `, " ")
+var ErrDiscovererClosed = errors.New("discoverer closed")
+
+const (
+	// advertisementTTL is the TTL of the records advertised by a Listener.
+	advertisementTTL = 120 * time.Second
+
+	// discoveryExpiryInterval is the interval at which discovered agents
+	// are checked for expiry.
+	discoveryExpiryInterval = time.Second
+)
+
+// DiscoveryEventType is the type of a DiscoveryEvent.
+type DiscoveryEventType int
+
+const (
+	// DiscoveryEventAdded is emitted when an agent is discovered.
+	DiscoveryEventAdded DiscoveryEventType = iota + 1
+	// DiscoveryEventUpdated is emitted when the nickname or TXT
+	// records of a discovered agent change.
+	DiscoveryEventUpdated
+	// DiscoveryEventRemoved is emitted when an agent sent a goodbye
+	// or its records expired.
+	DiscoveryEventRemoved
+)
+
+func (t DiscoveryEventType) String() string {
+	switch t {
+	case DiscoveryEventAdded:
+		return "added"
+	case DiscoveryEventUpdated:
+		return "updated"
+	case DiscoveryEventRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("DiscoveryEventType(%d)", int(t))
+	}
 }
 
-var ErrDiscovererClosed = errors.New("discoverer closed")
+// DiscoveryEvent describes a change in the set of discovered agents.
+type DiscoveryEvent struct {
+	Type  DiscoveryEventType
+	Agent *DiscoveredAgent
+}
 
 // Discover agents
 func Discover() (*Discoverer, error) {
@@ -35,8 +71,10 @@ type Discoverer struct {
 	mu sync.Mutex
 
 	remoteNickname *string
+	provider       DiscoveryProvider
+	log            logging.LeveledLogger
 
-	accept chan *DiscoveredAgent
+	events chan DiscoveryEvent
 
 	close    chan struct{}
 	closeErr error
@@ -47,7 +85,9 @@ type Discoverer struct {
 func NewDiscoverer() *Discoverer {
 	d := &Discoverer{
 		mu:       sync.Mutex{},
-		accept:   make(chan *DiscoveredAgent),
+		provider: NewMdnsDiscovery(),
+		log:      logging.NewDefaultLoggerFactory().NewLogger(logScopeDiscovery),
+		events:   make(chan DiscoveryEvent),
 		close:    make(chan struct{}),
 		closeErr: nil,
 		done:     make(chan struct{}),
@@ -61,62 +101,133 @@ func (d *Discoverer) WithNickname(nickname string) {
 	d.remoteNickname = &nickname
 }
 
+// WithDiscoveryProvider defines the provider used to browse for agents.
+// Defaults to mDNS.
+func (d *Discoverer) WithDiscoveryProvider(p DiscoveryProvider) {
+	d.provider = p
+}
+
+// WithLoggerFactory defines the logger factory of the discoverer.
+func (d *Discoverer) WithLoggerFactory(f logging.LoggerFactory) {
+	d.log = f.NewLogger(logScopeDiscovery)
+}
+
 // Start discovering agents
 func (d *Discoverer) Start() error {
 	return d.run()
 }
 
-func (d *Discoverer) run() error {
-	resolver, err := mdns.NewResolver(nil)
-	if err != nil {
-		return err
-	}
+// discoveredEntry tracks the lifetime of a discovered agent.
+type discoveredEntry struct {
+	agent   *DiscoveredAgent
+	expires time.Time // Zero if the agent doesn't expire.
+}
 
+func (d *Discoverer) run() error {
 	browseCtx, browseCancel := context.WithCancel(context.Background())
+	found := make(chan ServiceEvent)
 
-	entries := make(chan *mdns.ServiceEntry)
-
-	// Always use Browse instead of Lookup. Lookup has issues on Windows when
-	// server and client run in the same process (common in tests). We filter
-	// by nickname client-side instead.
-	err = resolver.Browse(browseCtx, MdnsServiceType, MdnsDomain, entries)
+	err := d.provider.Browse(browseCtx, found)
 	if err != nil {
 		browseCancel()
 		return err
 	}
 
-	acceptCh := d.accept
+	eventsCh := d.events
 	closeCh := d.close
 	doneCh := d.done
 	remoteNickname := d.remoteNickname
+	log := d.log
+
+	agents := make(map[PeerID]*discoveredEntry)
+
+	emit := func(t DiscoveryEventType, agent *DiscoveredAgent) bool {
+		log.Debugf("%s agent %s (%s)", t, agent.Nickname(), agent.PeerID)
+		select {
+		case eventsCh <- DiscoveryEvent{Type: t, Agent: agent}:
+			return true
+		case <-closeCh:
+			return false
+		}
+	}
+
+	remove := func(match func(*DiscoveredAgent) bool) bool {
+		for id, entry := range agents {
+			if !match(entry.agent) {
+				continue
+			}
+			delete(agents, id)
+			if !emit(DiscoveryEventRemoved, entry.agent) {
+				return false
+			}
+		}
+		return true
+	}
+
+	handleEvent := func(e ServiceEvent) bool {
+		// Filter by nickname if specified
+		if remoteNickname != nil && e.Instance.Instance != *remoteNickname {
+			return true
+		}
+
+		if e.Lost {
+			// Goodbyes may only carry the instance name.
+			fp, err := e.Instance.TXT.GetOne("fp")
+			if err == nil {
+				return remove(func(a *DiscoveredAgent) bool { return a.PeerID == PeerID(fp) })
+			}
+			return remove(func(a *DiscoveredAgent) bool { return a.instance.Instance == e.Instance.Instance })
+		}
+
+		agent, err := newDiscoveredAgent(e.Instance)
+		if err != nil {
+			log.Debugf("ignoring instance %s: %v", e.Instance.Instance, err)
+			return true
+		}
+
+		entry := &discoveredEntry{agent: agent}
+		if e.Instance.TTL > 0 {
+			entry.expires = time.Now().Add(e.Instance.TTL)
+		}
+		prev, ok := agents[agent.PeerID]
+		agents[agent.PeerID] = entry
+
+		// Repeated announcements only refresh the TTL.
+		switch {
+		case !ok:
+			return emit(DiscoveryEventAdded, agent)
+		case !prev.agent.instance.equal(agent.instance):
+			return emit(DiscoveryEventUpdated, agent)
+		default:
+			return true
+		}
+	}
 
 	// Run loop
 	go func() {
+		defer close(doneCh)
+		defer browseCancel()
+
+		expiryTicker := time.NewTicker(discoveryExpiryInterval)
+		defer expiryTicker.Stop()
+
 		for {
+			ok := true
 			select {
 			case <-closeCh:
-				browseCancel()
-				waitCloseMdns(entries)
-				close(doneCh)
 				return
 
-			case e, ok := <-entries:
-				// Ignore firing due to closed channel
-				if !ok {
-					continue
-				}
-				// Filter by nickname if specified (unescape DNS-SD encoding)
-				if remoteNickname != nil && unescapeDNSSD(e.ServiceRecord.Instance) != *remoteNickname {
-					continue
-				}
-				agent, err := newDiscoveredAgent(e)
-				if err != nil {
-					continue
-				}
-				select {
-				case acceptCh <- agent:
-				case <-closeCh:
-				}
+			case e := <-found:
+				ok = handleEvent(e)
+
+			case now := <-expiryTicker.C:
+				ok = remove(func(a *DiscoveredAgent) bool {
+					expires := agents[a.PeerID].expires
+					return !expires.IsZero() && !now.Before(expires)
+				})
+			}
+			if !ok {
+				return
 			}
 		}
 	}()
@@ -124,27 +235,36 @@ func (d *Discoverer) run() error {
 	return nil
 }
 
-// waitCloseMdns ensures mdns is fully shutdown.
-func waitCloseMdns(entries chan *mdns.ServiceEntry) {
-	// Drain channel
-	for range entries {
+// Accept returns an a discovered agent. It should be called in a loop.
+// Updates and removals of agents are skipped, use AcceptEvent to observe
+// those. Accept and AcceptEvent should not be used on the same Discoverer.
+func (d *Discoverer) Accept(ctx context.Context) (*DiscoveredAgent, error) {
+	for {
+		e, err := d.AcceptEvent(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == DiscoveryEventAdded {
+			return e.Agent, nil
+		}
 	}
 }
 
-// Accept returns an a discovered agent. It should be called in a loop.
-func (d *Discoverer) Accept(ctx context.Context) (*DiscoveredAgent, error) {
+// AcceptEvent returns the next change in the set of discovered agents.
+// It should be called in a loop.
+func (d *Discoverer) AcceptEvent(ctx context.Context) (DiscoveryEvent, error) {
 	d.mu.Lock()
-	acceptCh := d.accept
+	eventsCh := d.events
 	closeCh := d.close
 	d.mu.Unlock()
 
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
-	case a := <-acceptCh:
-		return a, nil
+		return DiscoveryEvent{}, ctx.Err()
+	case e := <-eventsCh:
+		return e, nil
 	case <-closeCh:
-		return nil, d.err()
+		return DiscoveryEvent{}, d.err()
 	}
 }
 
@@ -179,29 +299,30 @@ func (d *Discoverer) err() error {
 type DiscoveredAgent struct {
 	PeerID PeerID
 	TXT    TXTRecordSet
-	info   *mdns.ServiceEntry
+	// AuthInitiationToken is the at value advertised by the agent.
+	AuthInitiationToken string
+	instance            *ServiceInstance
 }
 
-func newDiscoveredAgent(info *mdns.ServiceEntry) (*DiscoveredAgent, error) {
-	txt := TXTRecordSet{}
-	err := txt.FromSlice(info.Text)
-	if err != nil {
-		return nil, err
-	}
-
-	fp, err := txt.GetOne("fp")
+func newDiscoveredAgent(instance *ServiceInstance) (*DiscoveredAgent, error) {
+	fp, err := instance.TXT.GetOne("fp")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get fp record: %v", err)
 	}
 
+	// The at record is optional, agents without it can't be
+	// checked against the auth-initiation-token.
+	at, _ := instance.TXT.GetOne("at")
+
 	return &DiscoveredAgent{
-		PeerID: PeerID(fp),
-		TXT:    txt,
-		info:   info,
+		PeerID:              PeerID(fp),
+		TXT:                 instance.TXT,
+		AuthInitiationToken: at,
+		instance:            instance,
 	}, nil
 }
 
 // Nickname of the remote agent
 func (a *DiscoveredAgent) Nickname() string {
-	return unescapeDNSSD(a.info.ServiceRecord.Instance)
+	return a.instance.Instance
 }
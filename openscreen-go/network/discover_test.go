@@ -0,0 +1,116 @@
+package ospc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryDiscoveryDial(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider := NewMemoryDiscovery()
+
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportQUIC, &ListenerConfig{
+		TXT: TXTRecordSet{
+			"fp": {"bogus"},
+			"mn": {"Kiosk"},
+		},
+	})
+	l.WithDiscoveryProvider(provider)
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := NewDiscoverer()
+	d.WithDiscoveryProvider(provider)
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	e, err := d.AcceptEvent(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != DiscoveryEventAdded {
+		t.Fatalf("unexpected event: %s", e.Type)
+	}
+	if e.Agent.PeerID != listenAgent.PeerID {
+		t.Fatalf("unexpected agent: %s", e.Agent.PeerID)
+	}
+	if e.Agent.Nickname() != "Listener" {
+		t.Fatalf("unexpected nickname: %s", e.Agent.Nickname())
+	}
+	// Additional records are advertised next to the ones of the agent.
+	if mn, _ := e.Agent.TXT.GetOne("mn"); mn != "Kiosk" {
+		t.Fatalf("unexpected mn record: %s", mn)
+	}
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dial through static discovery, without the sn record.
+	static := NewStaticDiscovery(StaticAgent{
+		Addr:                fmt.Sprintf("127.0.0.1:%d", e.Agent.instance.Port),
+		Fingerprint:         string(listenAgent.PeerID),
+		AuthInitiationToken: listenAgent.AuthInitiationToken(),
+	})
+	sd := NewDiscoverer()
+	sd.WithDiscoveryProvider(static)
+	err = sd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sd.Close()
+
+	staticAgent, err := sd.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staticAgent.AuthInitiationToken != listenAgent.AuthInitiationToken() {
+		t.Fatalf("unexpected auth-initiation-token: %s", staticAgent.AuthInitiationToken)
+	}
+
+	for _, ra := range []*DiscoveredAgent{e.Agent, staticAgent} {
+		uConn, err := ra.Dial(ctx, AgentTransportQUIC, dialAgent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uConn.RemoteAgent().PeerID != listenAgent.PeerID {
+			t.Fatalf("unexpected remote agent: %s", uConn.RemoteAgent().PeerID)
+		}
+
+		lConn, err := l.Accept(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lConn.RemoteAgent().PeerID != dialAgent.PeerID {
+			t.Fatalf("unexpected remote agent: %s", lConn.RemoteAgent().PeerID)
+		}
+
+		_ = uConn.Close()
+		_ = lConn.Close()
+	}
+
+	// Withdrawing the advertisement removes the agent.
+	_ = l.Close()
+	e, err = d.AcceptEvent(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != DiscoveryEventRemoved || e.Agent.PeerID != listenAgent.PeerID {
+		t.Fatalf("unexpected event: %s %s", e.Type, e.Agent.PeerID)
+	}
+}
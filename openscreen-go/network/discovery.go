@@ -0,0 +1,78 @@
+package ospc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// ServiceInstance is an agent as advertised or found by a DiscoveryProvider.
+type ServiceInstance struct {
+	// Instance is the instance name, the nickname of the agent.
+	Instance string
+	// Host is the hostname of the agent, used if Addrs is empty.
+	Host  string
+	Addrs []net.IP
+	Port  int
+	TXT   TXTRecordSet
+	// TTL is the time after which the instance is considered gone
+	// unless it is seen again. A zero TTL never expires.
+	TTL time.Duration
+}
+
+// ServiceEvent is reported by a DiscoveryProvider while browsing.
+type ServiceEvent struct {
+	Instance *ServiceInstance
+	// Lost is set if the instance announced that it went away.
+	// Only the Instance name and TXT records may be set in that case.
+	Lost bool
+}
+
+// DiscoveryProvider advertises and browses for agents.
+type DiscoveryProvider interface {
+	// Advertise publishes the instance until the returned io.Closer
+	// is closed.
+	Advertise(instance *ServiceInstance) (io.Closer, error)
+
+	// Browse starts browsing for agents in the background. Sightings
+	// are sent on events until ctx is cancelled. Instances are reported
+	// again when their records are refreshed. Implementations must stop
+	// sending once ctx is done.
+	Browse(ctx context.Context, events chan<- ServiceEvent) error
+}
+
+// clone returns a copy of the instance.
+func (s *ServiceInstance) clone() *ServiceInstance {
+	c := *s
+	c.Addrs = slices.Clone(s.Addrs)
+	c.TXT = maps.Clone(s.TXT)
+	return &c
+}
+
+// dialHost returns the host to dial the instance on.
+func (s *ServiceInstance) dialHost() string {
+	for _, ip := range s.Addrs {
+		if ip.To4() == nil {
+			return fmt.Sprintf("[%s]", ip)
+		}
+	}
+	for _, ip := range s.Addrs {
+		return ip.String()
+	}
+	return s.Host
+}
+
+// equal reports whether both instances advertise the same records.
+func (s *ServiceInstance) equal(o *ServiceInstance) bool {
+	return s.Instance == o.Instance &&
+		s.Port == o.Port &&
+		maps.EqualFunc(s.TXT, o.TXT, slices.Equal[[]string])
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
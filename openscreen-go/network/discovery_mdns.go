@@ -0,0 +1,297 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	mdns "github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+)
+
+// discoveryRefreshInterval is the interval at which the network is
+// queried again to refresh the TTL of discovered agents.
+const discoveryRefreshInterval = 30 * time.Second
+
+// unescapeDNSSD removes DNS-SD escaping from instance names.
+// The zeroconf library returns names with escaped special chars (e.g., "Test\ Receiver").
+func unescapeDNSSD(s string) string {
+	return strings.ReplaceAll(s, `\ `, " ")
+}
+
+var _ DiscoveryProvider = (*MdnsDiscovery)(nil)
+
+// MdnsDiscovery is a DiscoveryProvider using multicast DNS.
+type MdnsDiscovery struct {
+	// Interfaces to advertise and browse on. Defaults to all
+	// multicast capable interfaces.
+	Interfaces []net.Interface
+}
+
+// NewMdnsDiscovery creates a new MdnsDiscovery
+func NewMdnsDiscovery() *MdnsDiscovery {
+	return &MdnsDiscovery{}
+}
+
+func (p *MdnsDiscovery) Advertise(instance *ServiceInstance) (io.Closer, error) {
+	advertiser, err := mdns.Register(instance.Instance, MdnsServiceType, MdnsDomain, instance.Port, instance.TXT.ToSlice(), p.Interfaces)
+	if err != nil {
+		return nil, err
+	}
+	if instance.TTL > 0 {
+		advertiser.TTL(uint32(instance.TTL / time.Second))
+	}
+
+	return &mdnsAdvertisement{advertiser}, nil
+}
+
+type mdnsAdvertisement struct {
+	server *mdns.Server
+}
+
+func (a *mdnsAdvertisement) Close() error {
+	a.server.Shutdown()
+	return nil
+}
+
+func (p *MdnsDiscovery) Browse(ctx context.Context, events chan<- ServiceEvent) error {
+	// Fail early if mDNS is unavailable. The first browse uses this resolver.
+	resolver, err := p.newResolver()
+	if err != nil {
+		return err
+	}
+
+	// Goodbyes are best effort, without them agents are removed
+	// once their records expire.
+	goodbyeConn, err := listenMdnsGoodbyes(ctx, p.Interfaces, events)
+	if err != nil {
+		goodbyeConn = nil
+	}
+
+	go func() {
+		if goodbyeConn != nil {
+			defer goodbyeConn.Close()
+		}
+
+		for {
+			// A single Browse only reports each entry once. Browse again
+			// periodically to learn about refreshed and changed records.
+			browseCtx, browseCancel := context.WithTimeout(ctx, discoveryRefreshInterval)
+			// Failures are retried in the next cycle.
+			entries, _ := p.browse(browseCtx, resolver)
+
+		cycle:
+			for {
+				select {
+				case e, ok := <-entries:
+					if !ok {
+						entries = nil
+						continue
+					}
+					instance, err := newMdnsServiceInstance(e)
+					if err != nil {
+						continue
+					}
+					select {
+					case events <- ServiceEvent{Instance: instance}:
+					case <-browseCtx.Done():
+					}
+
+				case <-browseCtx.Done():
+					break cycle
+				}
+			}
+			browseCancel()
+			if entries != nil {
+				waitCloseMdns(entries)
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			// A resolver can't be reused once its Browse is cancelled.
+			resolver = nil
+		}
+	}()
+
+	return nil
+}
+
+func (p *MdnsDiscovery) newResolver() (*mdns.Resolver, error) {
+	return mdns.NewResolver(mdns.SelectIfaces(p.Interfaces))
+}
+
+// browse browses for agents using resolver or a new resolver
+// if resolver is nil.
+func (p *MdnsDiscovery) browse(ctx context.Context, resolver *mdns.Resolver) (chan *mdns.ServiceEntry, error) {
+	if resolver == nil {
+		var err error
+		resolver, err = p.newResolver()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make(chan *mdns.ServiceEntry)
+
+	// Always use Browse instead of Lookup. Lookup has issues on Windows when
+	// server and client run in the same process (common in tests). We filter
+	// by nickname client-side instead.
+	err := resolver.Browse(ctx, MdnsServiceType, MdnsDomain, entries)
+	if err != nil {
+		// The entries channel is closed once the browse is cancelled.
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// waitCloseMdns ensures mdns is fully shutdown.
+func waitCloseMdns(entries chan *mdns.ServiceEntry) {
+	// Drain channel
+	for range entries {
+	}
+}
+
+func newMdnsServiceInstance(e *mdns.ServiceEntry) (*ServiceInstance, error) {
+	txt := TXTRecordSet{}
+	err := txt.FromSlice(e.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []net.IP
+	addrs = append(addrs, e.AddrIPv6...)
+	addrs = append(addrs, e.AddrIPv4...)
+
+	return &ServiceInstance{
+		Instance: unescapeDNSSD(e.ServiceRecord.Instance),
+		Host:     e.HostName,
+		Addrs:    addrs,
+		Port:     e.Port,
+		TXT:      txt,
+		TTL:      time.Duration(e.TTL) * time.Second,
+	}, nil
+}
+
+// mDNS multicast groups, goodbyes are received on both.
+var (
+	mdnsGroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	mdnsGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: 5353}
+)
+
+// listenMdnsGoodbyes listens for mDNS goodbye packets of agents and reports
+// the departing agents as lost. The mDNS client ignores records with a TTL
+// of zero, so goodbyes are picked up passively. The groups are joined on
+// each of the interfaces, or on all multicast capable interfaces if none
+// are given.
+func listenMdnsGoodbyes(ctx context.Context, ifaces []net.Interface, events chan<- ServiceEvent) (io.Closer, error) {
+	if len(ifaces) == 0 {
+		var err error
+		ifaces, err = multicastInterfaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l := &mdnsGoodbyeListener{}
+	var lastErr error
+	for i := range ifaces {
+		for _, group := range []struct {
+			network string
+			addr    *net.UDPAddr
+		}{
+			{"udp4", mdnsGroupIPv4},
+			{"udp6", mdnsGroupIPv6},
+		} {
+			conn, err := net.ListenMulticastUDP(group.network, &ifaces[i], group.addr)
+			if err != nil {
+				// The interface may not support this IP version.
+				lastErr = err
+				continue
+			}
+			l.conns = append(l.conns, conn)
+			go readMdnsGoodbyes(ctx, conn, events)
+		}
+	}
+	if len(l.conns) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no multicast interfaces")
+		}
+		return nil, lastErr
+	}
+
+	return l, nil
+}
+
+// multicastInterfaces returns the interfaces that are up and multicast
+// capable.
+func multicastInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ifaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+type mdnsGoodbyeListener struct {
+	conns []*net.UDPConn
+}
+
+func (l *mdnsGoodbyeListener) Close() error {
+	var err error
+	for _, conn := range l.conns {
+		if cErr := conn.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// readMdnsGoodbyes reads the packets received on conn until it's closed.
+func readMdnsGoodbyes(ctx context.Context, conn *net.UDPConn, events chan<- ServiceEvent) {
+	serviceName := fmt.Sprintf("%s.%s.", MdnsServiceType, MdnsDomain)
+
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		msg := new(dns.Msg)
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		for _, rr := range msg.Answer {
+			ptr, ok := rr.(*dns.PTR)
+			if !ok || ptr.Hdr.Ttl != 0 || ptr.Hdr.Name != serviceName {
+				continue
+			}
+			instance := strings.TrimSuffix(strings.TrimSuffix(ptr.Ptr, serviceName), ".")
+
+			select {
+			case events <- ServiceEvent{
+				Instance: &ServiceInstance{Instance: unescapeDNSSD(instance)},
+				Lost:     true,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ospc
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+var _ DiscoveryProvider = (*MemoryDiscovery)(nil)
+
+// MemoryDiscovery is a DiscoveryProvider that keeps a registry of agents
+// in memory. It allows agents in the same process to find each other,
+// e.g., in tests. Instances advertised without addresses are reported
+// on the loopback address.
+type MemoryDiscovery struct {
+	mu        sync.Mutex
+	nextID    int
+	instances map[int]*ServiceInstance
+	watchers  map[*memoryWatcher]struct{}
+}
+
+// NewMemoryDiscovery creates an empty MemoryDiscovery.
+func NewMemoryDiscovery() *MemoryDiscovery {
+	return &MemoryDiscovery{
+		instances: make(map[int]*ServiceInstance),
+		watchers:  make(map[*memoryWatcher]struct{}),
+	}
+}
+
+func (p *MemoryDiscovery) Advertise(instance *ServiceInstance) (io.Closer, error) {
+	instance = instance.clone()
+	if len(instance.Addrs) == 0 && instance.Host == "" {
+		instance.Addrs = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextID
+	p.nextID++
+	p.instances[id] = instance
+
+	for w := range p.watchers {
+		w.push(ServiceEvent{Instance: instance.clone()})
+	}
+
+	return &memoryAdvertisement{p: p, id: id}, nil
+}
+
+func (p *MemoryDiscovery) withdraw(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instance, ok := p.instances[id]
+	if !ok {
+		return
+	}
+	delete(p.instances, id)
+
+	for w := range p.watchers {
+		w.push(ServiceEvent{Instance: instance.clone(), Lost: true})
+	}
+}
+
+func (p *MemoryDiscovery) Browse(ctx context.Context, events chan<- ServiceEvent) error {
+	w := &memoryWatcher{
+		signal: make(chan struct{}, 1),
+	}
+
+	p.mu.Lock()
+	for _, instance := range p.instances {
+		w.push(ServiceEvent{Instance: instance.clone()})
+	}
+	p.watchers[w] = struct{}{}
+	p.mu.Unlock()
+
+	go func() {
+		w.run(ctx, events)
+
+		p.mu.Lock()
+		delete(p.watchers, w)
+		p.mu.Unlock()
+	}()
+
+	return nil
+}
+
+type memoryAdvertisement struct {
+	p    *MemoryDiscovery
+	id   int
+	once sync.Once
+}
+
+func (a *memoryAdvertisement) Close() error {
+	a.once.Do(func() {
+		a.p.withdraw(a.id)
+	})
+	return nil
+}
+
+// memoryWatcher queues events for a browser so advertising never
+// blocks on a slow reader.
+type memoryWatcher struct {
+	mu     sync.Mutex
+	queue  []ServiceEvent
+	signal chan struct{}
+}
+
+func (w *memoryWatcher) push(e ServiceEvent) {
+	w.mu.Lock()
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) run(ctx context.Context, events chan<- ServiceEvent) {
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.signal:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
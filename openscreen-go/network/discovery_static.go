@@ -0,0 +1,94 @@
+package ospc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// StaticAgent is an agent at a known address, identified by the
+// fingerprint of its certificate.
+type StaticAgent struct {
+	// Nickname of the agent. Defaults to Addr.
+	Nickname    string
+	Addr        string // host:port
+	Fingerprint string
+	// AuthInitiationToken is the at value of the agent, required to
+	// authenticate with a PSK.
+	AuthInitiationToken string
+}
+
+var _ DiscoveryProvider = (*StaticDiscovery)(nil)
+
+// StaticDiscovery is a DiscoveryProvider that reports a fixed list of
+// agents. It can be used on networks without multicast.
+type StaticDiscovery struct {
+	agents []StaticAgent
+}
+
+// NewStaticDiscovery creates a StaticDiscovery reporting the given agents.
+func NewStaticDiscovery(agents ...StaticAgent) *StaticDiscovery {
+	return &StaticDiscovery{
+		agents: agents,
+	}
+}
+
+// Advertise does nothing, static agents are configured on the browsing side.
+func (p *StaticDiscovery) Advertise(instance *ServiceInstance) (io.Closer, error) {
+	return nopCloser{}, nil
+}
+
+func (p *StaticDiscovery) Browse(ctx context.Context, events chan<- ServiceEvent) error {
+	instances := make([]*ServiceInstance, 0, len(p.agents))
+	for _, a := range p.agents {
+		instance, err := a.serviceInstance()
+		if err != nil {
+			return err
+		}
+		instances = append(instances, instance)
+	}
+
+	go func() {
+		for _, instance := range instances {
+			select {
+			case events <- ServiceEvent{Instance: instance}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (a StaticAgent) serviceInstance() (*ServiceInstance, error) {
+	host, portStr, err := net.SplitHostPort(a.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", a.Addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s: %w", portStr, err)
+	}
+
+	instance := &ServiceInstance{
+		Instance: a.Nickname,
+		Host:     host,
+		Port:     port,
+		TXT:      TXTRecordSet{},
+	}
+	if instance.Instance == "" {
+		instance.Instance = a.Addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		instance.Addrs = []net.IP{ip}
+	}
+	instance.TXT.Set("fp", a.Fingerprint)
+	if a.AuthInitiationToken != "" {
+		instance.TXT.Set("at", a.AuthInitiationToken)
+	}
+
+	return instance, nil
+}
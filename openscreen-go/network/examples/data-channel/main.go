@@ -50,7 +50,7 @@ func simPeerA() error {
 		return err
 	}
 
-	l, err := ospc.Listen(ospc.AgentTransportQUIC, a)
+	l, err := ospc.Listen(ospc.AgentTransportQUIC, a, nil)
 	if err != nil {
 		return err
 	}
@@ -52,7 +52,7 @@ func runServer() error {
 		return err
 	}
 
-	l, err := ospc.Listen(ospc.AgentTransportQUIC, a)
+	l, err := ospc.Listen(ospc.AgentTransportQUIC, a, nil)
 	if err != nil {
 		return err
 	}
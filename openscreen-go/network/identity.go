@@ -0,0 +1,202 @@
+package ospc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/backkem/go-lp2p/internal/atomicfile"
+)
+
+// certificateRenewalMargin is the remaining validity below which a loaded
+// certificate is regenerated.
+const certificateRenewalMargin = 7 * 24 * time.Hour
+
+// agentIdentity is the persisted form of the identity of an Agent.
+type agentIdentity struct {
+	Certificate          []byte `json:"certificate"` // DER
+	PrivateKey           []byte `json:"private_key"` // PKCS #8
+	CertificateSNBase    uint32 `json:"certificate_sn_base"`
+	CertificateSNCounter uint32 `json:"certificate_sn_counter"`
+}
+
+// WriteIdentity serializes the certificate, private key and serial number
+// state of the agent to w.
+func (a *Agent) WriteIdentity(w io.Writer) error {
+	a.mu.Lock()
+	cert := a.Certificate
+	id := agentIdentity{
+		CertificateSNBase:    a.CertificateSNBase,
+		CertificateSNCounter: a.CertificateSNCounter,
+	}
+	a.mu.Unlock()
+
+	if cert == nil || len(cert.Certificate) < 1 {
+		return errors.New("agent has no certificate")
+	}
+	id.Certificate = cert.Certificate[0]
+
+	privKey, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	id.PrivateKey = privKey
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(id)
+}
+
+// SaveIdentity writes the identity of the agent to the file at path.
+// The file is replaced atomically and is only readable by the owner.
+func (a *Agent) SaveIdentity(path string) error {
+	buf := new(bytes.Buffer)
+	err := a.WriteIdentity(buf)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), 0600)
+}
+
+// ReadAgent rebuilds an Agent from an identity written by WriteIdentity.
+// The remaining fields of the AgentConfig are applied as in NewAgent.
+// If the stored certificate is about to expire or doesn't match the
+// DisplayName of the config, a new certificate is issued for the
+// stored key.
+func ReadAgent(r io.Reader, c AgentConfig) (*Agent, error) {
+	a, _, err := readAgent(r, c)
+	return a, err
+}
+
+// LoadAgent rebuilds an Agent from the identity file at path.
+// If the certificate had to be regenerated, the file is updated
+// to persist the incremented serial number counter.
+func LoadAgent(path string, c AgentConfig) (*Agent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a, renewed, err := readAgent(f, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity %s: %w", path, err)
+	}
+
+	if renewed {
+		err = a.SaveIdentity(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// LoadOrCreateAgent loads the Agent identity from the file at path. If the file
+// doesn't exist, a new Agent is created and its identity is saved to path.
+func LoadOrCreateAgent(path string, c AgentConfig) (*Agent, error) {
+	a, err := LoadAgent(path, c)
+	if err == nil {
+		return a, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	a, err = NewAgent(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.SaveIdentity(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func readAgent(r io.Reader, c AgentConfig) (*Agent, bool, error) {
+	var id agentIdentity
+	err := json.NewDecoder(r).Decode(&id)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode identity: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(id.Certificate)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	rawKey, err := x509.ParsePKCS8PrivateKey(id.PrivateKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	privKey, ok := rawKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, false, fmt.Errorf("unsupported private key type: %T", rawKey)
+	}
+	if !privKey.PublicKey.Equal(leaf.PublicKey) {
+		return nil, false, errors.New("private key doesn't match certificate")
+	}
+
+	rawPeerID, err := certificateFingerPrint(leaf)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.PeerID = PeerID(rawPeerID)
+	c.Certificate = &tls.Certificate{
+		Certificate: [][]byte{id.Certificate},
+		PrivateKey:  privKey,
+		Leaf:        leaf,
+	}
+	c.CertificateSNBase = id.CertificateSNBase
+	c.CertificateSNCounter = id.CertificateSNCounter
+
+	a, err := NewAgent(c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !needsRenewal(leaf, c.DisplayName, id.CertificateSNBase, id.CertificateSNCounter) {
+		return a, false, nil
+	}
+
+	err = a.RegenerateCertificate()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return a, true, nil
+}
+
+func needsRenewal(leaf *x509.Certificate, displayName string, certificateSNBase, certificateSNCounter uint32) bool {
+	if time.Now().Add(certificateRenewalMargin).After(leaf.NotAfter) {
+		return true
+	}
+
+	serialNumber := uint64(certificateSNBase)<<32 | uint64(certificateSNCounter)
+	hostname := agentHostname(displayName, serialNumber)
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != hostname {
+		return true
+	}
+
+	return false
+}
+
+// writeFileAtomic writes data to path without leaving a truncated file
+// behind on a crash.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return atomicfile.WriteFile(path, data, perm)
+}
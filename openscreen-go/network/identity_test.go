@@ -0,0 +1,73 @@
+package ospc
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentityRoundTrip(t *testing.T) {
+	c := NewAgentConfig("Agent007")
+	orig, err := NewAgent(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	err = orig.WriteIdentity(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := ReadAgent(buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual.PeerID != orig.PeerID {
+		t.Fatalf("different PeerID: %s != %s", actual.PeerID, orig.PeerID)
+	}
+	if actual.CertificateSNBase != orig.CertificateSNBase {
+		t.Fatalf("different CertificateSNBase")
+	}
+	if actual.CertificateSNCounter != orig.CertificateSNCounter {
+		t.Fatalf("different CertificateSNCounter: %d != %d", actual.CertificateSNCounter, orig.CertificateSNCounter)
+	}
+	if !bytes.Equal(actual.Certificate.Certificate[0], orig.Certificate.Certificate[0]) {
+		t.Fatalf("different certificate")
+	}
+}
+
+func TestIdentityRegenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+
+	orig, err := LoadOrCreateAgent(path, NewAgentConfig("Agent007"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new display name requires a new certificate.
+	renamed, err := LoadAgent(path, NewAgentConfig("Agent008"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renamed.PeerID != orig.PeerID {
+		t.Fatalf("PeerID changed on regeneration")
+	}
+	if renamed.CertificateSNCounter != orig.CertificateSNCounter+1 {
+		t.Fatalf("counter not incremented: %d", renamed.CertificateSNCounter)
+	}
+	if renamed.Certificate.Leaf.SerialNumber.Cmp(orig.Certificate.Leaf.SerialNumber) == 0 {
+		t.Fatalf("serial number not changed")
+	}
+
+	// The incremented counter is persisted.
+	reloaded, err := LoadAgent(path, NewAgentConfig("Agent008"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.CertificateSNCounter != renamed.CertificateSNCounter {
+		t.Fatalf("counter not persisted: %d", reloaded.CertificateSNCounter)
+	}
+}
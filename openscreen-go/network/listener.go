@@ -9,17 +9,36 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 
-	mdns "github.com/grandcat/zeroconf"
+	"github.com/pion/logging"
 )
 
 var ErrListenerClosed = errors.New("listener closed")
 
+// ListenerConfig is used to configure a Listener.
+type ListenerConfig struct {
+	// Addr is the local address to listen on, e.g., ":4433" to use a fixed
+	// port or "192.168.1.2:0" to bind to a single interface. Defaults to a
+	// random port on all interfaces.
+	Addr string
+
+	// Interfaces are the network interfaces to advertise on using mDNS.
+	// Defaults to all multicast capable interfaces. Ignored if another
+	// DiscoveryProvider is used.
+	Interfaces []net.Interface
+
+	// TXT holds additional records advertised next to the ones of the
+	// agent. They can't replace the records of the agent.
+	TXT TXTRecordSet
+}
+
 // Listen starts an advertising agent and listens for incoming connections.
-func Listen(transportType AgentTransport, a *Agent) (*Listener, error) {
-	l := NewListener(a, transportType)
+// A nil config uses the defaults.
+func Listen(transportType AgentTransport, a *Agent, config *ListenerConfig) (*Listener, error) {
+	l := NewListener(a, transportType, config)
 
 	err := l.run()
 	if err != nil {
@@ -36,6 +55,11 @@ type Listener struct {
 
 	agent         *Agent
 	transportType AgentTransport
+	addr          string
+	txt           TXTRecordSet
+	discovery     DiscoveryProvider
+	listener      NetworkListener
+	log           logging.LeveledLogger
 
 	accept chan *UnauthenticatedConnection
 
@@ -46,13 +70,28 @@ type Listener struct {
 	done     chan struct{}
 }
 
-// NewListener creates a new Listener
-func NewListener(a *Agent, transportType AgentTransport) *Listener {
+// NewListener creates a new Listener. A nil config uses the defaults.
+func NewListener(a *Agent, transportType AgentTransport, config *ListenerConfig) *Listener {
+	if config == nil {
+		config = &ListenerConfig{}
+	}
+	addr := config.Addr
+	if addr == "" {
+		addr = ":"
+	}
+
 	l := &Listener{
 		mu:            sync.Mutex{},
 		agent:         a,
 		transportType: transportType,
+		addr:          addr,
+		txt:           config.TXT,
+		discovery: &MdnsDiscovery{
+			Interfaces: config.Interfaces,
+		},
+		log:           a.loggerFactory.NewLogger(logScopeTransport),
 		accept:        make(chan *UnauthenticatedConnection),
+		alpnListeners: make(map[string]*ALPNListener),
 		close:         make(chan struct{}),
 		closeErr:      nil,
 		done:          make(chan struct{}),
@@ -61,6 +100,12 @@ func NewListener(a *Agent, transportType AgentTransport) *Listener {
 	return l
 }
 
+// WithDiscoveryProvider defines the provider used to advertise the agent.
+// Defaults to mDNS. Needs to be set before starting the Listener.
+func (l *Listener) WithDiscoveryProvider(p DiscoveryProvider) {
+	l.discovery = p
+}
+
 // ListenApplication allows you to listen for quic connections
 // on the same port but with a different ALPN. Only one per ALPN
 // is allowed. Needs to be registered before starting the Listener.
@@ -170,10 +215,13 @@ func (l *Listener) run() error {
 	closeCh := l.close
 	doneCh := l.done
 
-	// List all registered ALPN_OSPs.
+	// List all registered ALPN_OSPs. Applications are dispatched as
+	// QUIC connections, other transports only offer OSP.
 	nextProtos := []string{ALPN_OSP}
-	for k := range l.alpnListeners {
-		nextProtos = append(nextProtos, k)
+	if l.transportType == AgentTransportQUIC {
+		for k := range l.alpnListeners {
+			nextProtos = append(nextProtos, k)
+		}
 	}
 
 	// Listen for and handle incoming connections
@@ -193,7 +241,11 @@ func (l *Listener) run() error {
 
 			alpn := cs.NegotiatedProtocol
 			if alpn != ALPN_OSP {
-				return l.getALPNListener(alpn).doVerifyConnection(cs)
+				child := l.getALPNListener(alpn)
+				if child == nil {
+					return fmt.Errorf("no listener for ALPN: %s", alpn)
+				}
+				return child.doVerifyConnection(cs)
 			}
 
 			peerCert := cs.PeerCertificates[0]
@@ -213,14 +265,22 @@ func (l *Listener) run() error {
 		},
 	}
 
-	t, err := NewNetworkTransport(l.transportType)
+	t, err := NewNetworkTransport(l.transportType, l.agent.loggerFactory)
 	if err != nil {
 		return err
 	}
-	listener, err := t.ListenAddr(":", tlsConfig)
+	listener, err := t.ListenAddr(l.addr, tlsConfig)
 	if err != nil {
 		return err
 	}
+	// Release the socket if the listener fails to start, otherwise a
+	// retry on the same port fails.
+	started := false
+	defer func() {
+		if !started {
+			_ = listener.Close()
+		}
+	}()
 
 	fp, err := l.agent.CertificateFingerPrint()
 	if err != nil {
@@ -231,10 +291,13 @@ func (l *Listener) run() error {
 	writeVaruint(0, mvBuf) // TODO: metadata updates
 	mv := mvBuf.String()
 
-	at := randomAT(9)
+	at := l.agent.AuthInitiationToken()
 
 	// Advertise ourselves
 	txt := TXTRecordSet{}
+	for key, values := range l.txt {
+		txt[key] = append([]string(nil), values...)
+	}
 	txt.Set("fp", fp)
 	txt.Set("mv", mv)
 	txt.Set("at", at)
@@ -245,11 +308,26 @@ func (l *Listener) run() error {
 	snBytes := l.agent.Certificate.Leaf.SerialNumber.Bytes()
 	snBase64 := base64.RawURLEncoding.EncodeToString(snBytes)
 	txt.Set("sn", snBase64) // TODO: openscreenprotocol#293
-	port := listener.Addr().(*net.UDPAddr).Port
-	advertiser, err := mdns.Register(l.agent.info.DisplayName, MdnsServiceType, MdnsDomain, port, txt.ToSlice(), nil)
+	_, portStr, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
 	if err != nil {
 		return err
 	}
+	advertiser, err := l.discovery.Advertise(&ServiceInstance{
+		Instance: l.agent.info.DisplayName,
+		Port:     port,
+		TXT:      txt,
+		// A short TTL lets discoverers notice agents that left without a goodbye.
+		TTL: advertisementTTL,
+	})
+	if err != nil {
+		return err
+	}
+	l.listener = listener
+	started = true
 
 	acceptCtx, acceptCancel := context.WithCancel(context.Background())
 	netConns := make(chan NetworkConnection)
@@ -257,17 +335,25 @@ func (l *Listener) run() error {
 		for {
 			nc, err := listener.Accept(acceptCtx)
 			if err != nil {
-				fmt.Printf("AcceptListener error: %s\n", err)
+				l.log.Debugf("AcceptListener error: %s", err)
 				// TODO: Close early here?
 				return
 			}
 
 			alpn := nc.ConnectionState().NegotiatedProtocol
 			if alpn != ALPN_OSP {
+				child := l.getALPNListener(alpn)
+				if child == nil {
+					// The listener was closed after the handshake.
+					_ = nc.Close()
+					continue
+				}
 				if qnc, ok := nc.(*QuicNetworkConnection); ok {
-					l.getALPNListener(alpn).dispatch(qnc.conn)
+					// Don't block incoming OSP connections.
+					go child.dispatch(qnc.conn)
 				} else {
-					panic("TODO: generalize ALPNListener")
+					l.log.Warnf("dropping %s connection: application requires QUIC", alpn)
+					_ = nc.Close()
 				}
 				continue
 			}
@@ -285,8 +371,9 @@ func (l *Listener) run() error {
 		for {
 			select {
 			case <-closeCh: // Shutdown initiated
-				advertiser.Shutdown()
+				advertiser.Close()
 				acceptCancel()
+				listener.Close()
 
 				for _, conn := range pendingConns {
 					_ = conn.Close()
@@ -298,7 +385,7 @@ func (l *Listener) run() error {
 			case nc := <-netConns: // Incoming connection
 				remoteAgent, err := l.agent.NewRemoteAgent(nc)
 				if err != nil {
-					fmt.Printf("failed to create remote agent: %v\n", err)
+					l.log.Warnf("failed to create remote agent: %v", err)
 					continue
 				}
 				bConn := newBaseConnection(
@@ -310,12 +397,24 @@ func (l *Listener) run() error {
 
 				bConn.runNetwork()
 
-				err = bConn.exchangeInfo(context.Background(), pendingCh)
+				done, err := bConn.exchangeInfo(context.Background())
 				if err != nil {
-					fmt.Printf("failed to exchange metadata: %v\n", err)
+					l.log.Warnf("failed to exchange metadata: %v", err)
 					bConn.closeWithError(fmt.Errorf("failed to exchange metadata: %v", err))
 				} else {
 					pendingConns = append(pendingConns, bConn)
+					// The result is kept by the connection while the
+					// run loop is busy, e.g., handing over a connection.
+					go func() {
+						select {
+						case res := <-done:
+							select {
+							case pendingCh <- res:
+							case <-closeCh:
+							}
+						case <-closeCh:
+						}
+					}()
 				}
 
 			case res := <-pendingCh: // Connection with metadata available
@@ -326,8 +425,11 @@ func (l *Listener) run() error {
 				if err != nil {
 					break
 				}
-				uConn := &UnauthenticatedConnection{
-					base: bConn,
+				uConn, err := newUnauthenticatedConnection(bConn)
+				if err != nil {
+					l.log.Warnf("failed to connect trusted peer: %v", err)
+					bConn.closeWithError(err)
+					break
 				}
 
 				select {
@@ -368,8 +470,17 @@ func (l *Listener) Accept(ctx context.Context) (*UnauthenticatedConnection, erro
 	}
 }
 
-// func (l *Listener) Addr() net.Addr {
-// }
+// Addr returns the local address the listener is bound to.
+// It returns nil if the listener isn't started.
+func (l *Listener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.listener == nil {
+		return nil
+	}
+	return l.listener.Addr()
+}
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
@@ -399,9 +510,38 @@ func (l *Listener) err() error {
 }
 
 // UnauthenticatedConnection represents an OSPC connection that didn't pass
-// authentication yet.
+// authentication yet. If the local and remote agent trust each other from
+// a previous authentication, the connection is authenticated right away,
+// see Authenticated.
 type UnauthenticatedConnection struct {
 	base *baseConnection
+
+	// conn is set if the connection was authenticated as trusted peer.
+	conn *Connection
+}
+
+func newUnauthenticatedConnection(bConn *baseConnection) (*UnauthenticatedConnection, error) {
+	if !bConn.IsMutuallyTrusted() {
+		return &UnauthenticatedConnection{
+			base: bConn,
+		}, nil
+	}
+
+	conn, err := bConn.finishTrustedAuthentication()
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnauthenticatedConnection{
+		base: bConn,
+		conn: conn,
+	}, nil
+}
+
+// Authenticated returns the authenticated Connection if the remote agent is
+// a trusted peer and PSK authentication was skipped.
+func (c *UnauthenticatedConnection) Authenticated() (*Connection, bool) {
+	return c.conn, c.conn != nil
 }
 
 // LocalAgent provides info about local agent
@@ -438,7 +578,12 @@ func (c *UnauthenticatedConnection) AcceptAuthenticate(ctx context.Context) (rol
 
 // Authenticate is used to authenticate. It will block until authentication is complete
 // or the context is closed.
+// If the connection was already authenticated as trusted peer, the
+// Connection is returned right away.
 func (c *UnauthenticatedConnection) AuthenticatePSK(ctx context.Context, psk []byte) (*Connection, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
 	base := c.base
 
 	conn, err := base.AuthenticatePSK(ctx, psk)
@@ -456,7 +601,7 @@ func (c *UnauthenticatedConnection) AuthenticatePSK(ctx context.Context, psk []b
 // If the connection has progressed to authenticated, it is not closed
 // but an error is returned. This allows for defer closing regardless.
 func (c *UnauthenticatedConnection) Close() error {
-	if c.base == nil {
+	if c.base == nil || c.conn != nil {
 		return errors.New("already authenticated")
 	}
 	return c.base.Close()
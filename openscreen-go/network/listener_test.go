@@ -1,6 +1,11 @@
 package ospc
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
 
 func TestTXTRecordSet(t *testing.T) {
 	orig := TXTRecordSet{}
@@ -38,3 +43,46 @@ func TestTXTRecordSet(t *testing.T) {
 		t.Fatalf("wrong at: %s != baz", actualFp)
 	}
 }
+
+// failingDiscovery is a DiscoveryProvider that can't advertise.
+type failingDiscovery struct {
+	*MemoryDiscovery
+}
+
+func (failingDiscovery) Advertise(*ServiceInstance) (io.Closer, error) {
+	return nil, errors.New("advertise failed")
+}
+
+func TestListenerStartFailure(t *testing.T) {
+	// Find a free port.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	_ = conn.Close()
+
+	agent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewListener(agent, AgentTransportQUIC, &ListenerConfig{Addr: addr})
+	l.WithDiscoveryProvider(failingDiscovery{NewMemoryDiscovery()})
+	err = l.Start()
+	if err == nil {
+		t.Fatal("expected advertise error")
+	}
+
+	// The port is released, starting again succeeds.
+	l = NewListener(agent, AgentTransportQUIC, &ListenerConfig{Addr: addr})
+	l.WithDiscoveryProvider(NewMemoryDiscovery())
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != addr {
+		t.Fatalf("unexpected address: %s", l.Addr())
+	}
+}
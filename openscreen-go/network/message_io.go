@@ -2,6 +2,7 @@ package ospc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 
@@ -18,28 +19,29 @@ func writeTypeKey(v TypeKey, w io.Writer) error {
 }
 
 func readMessage(r io.Reader) (interface{}, error) {
-	// r = newDebugReadWriter(r)
 	typeKey, err := readTypeKey(r)
 	if err != nil {
 		return nil, err
 	}
 
-	// fmt.Println("Got typeKey", typeKey)
-
 	msg, err := newMessageByType(typeKey)
 	if err != nil {
 		return nil, err
 	}
 
-	dec := cbor.NewDecoder(r)
-	err = dec.Decode(msg)
+	// Read exactly one item so consecutive messages on the same
+	// stream aren't consumed by the decoder.
+	data, err := readCBORItem(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cbor.Unmarshal(data, msg)
 	if err != nil {
 		return nil, fmt.Errorf("cbor decode error: %w", err)
 	}
 
-	fmt.Printf("<-- Read %T\n", msg)
 	return msg, nil
-
 }
 
 // Write with type/size prefix.
@@ -47,8 +49,6 @@ func readMessage(r io.Reader) (interface{}, error) {
 // so that it is emitted as a single Write call. This is required when
 // each Write opens a new unidirectional QUIC stream.
 func writeMessage(msg interface{}, w io.Writer) error {
-	fmt.Printf("  --> Writing %T\n", msg)
-
 	tKey, err := typeKeyByMessage(msg)
 	if err != nil {
 		return err
@@ -102,7 +102,133 @@ func EncodeCBOR(v interface{}, w io.Writer) error {
 }
 
 // DecodeCBOR decodes a CBOR-encoded value from the reader.
+// It doesn't read beyond the end of the value.
 func DecodeCBOR(r io.Reader, v interface{}) error {
-	dec := cbor.NewDecoder(r)
-	return dec.Decode(v)
+	data, err := readCBORItem(r)
+	if err != nil {
+		return err
+	}
+	return cbor.Unmarshal(data, v)
+}
+
+// maxCBORItemLength limits the length of byte strings, text strings,
+// arrays and maps read by readCBORItem.
+const maxCBORItemLength = 16 << 20
+
+// readCBORItem reads the encoding of a single CBOR data item from r
+// without reading any further.
+func readCBORItem(r io.Reader) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := readCBORItemInto(r, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readCBORItemInto copies a single CBOR data item from r to buf.
+func readCBORItemInto(r io.Reader, buf *bytes.Buffer, depth int) error {
+	var initial [1]byte
+	_, err := io.ReadFull(r, initial[:])
+	if err != nil {
+		return err
+	}
+	return readCBORItemFrom(r, buf, initial[0], depth)
+}
+
+// readCBORItemFrom copies a CBOR data item, of which the initial byte was
+// already read, from r to buf.
+func readCBORItemFrom(r io.Reader, buf *bytes.Buffer, initial byte, depth int) error {
+	if depth > 32 {
+		return errors.New("cbor: nesting too deep")
+	}
+	buf.WriteByte(initial)
+
+	major := initial >> 5
+	info := initial & 0x1f
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		var b [8]byte
+		n := 1 << (info - 24)
+		_, err := io.ReadFull(r, b[:n])
+		if err != nil {
+			return noEOF(err)
+		}
+		buf.Write(b[:n])
+		for _, v := range b[:n] {
+			arg = arg<<8 | uint64(v)
+		}
+	case info == 31:
+		return readCBORIndefinite(r, buf, major, depth)
+	default:
+		return fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+
+	switch major {
+	case 2, 3: // byte & text strings
+		if arg > maxCBORItemLength {
+			return fmt.Errorf("cbor: string too long: %d", arg)
+		}
+		_, err := io.CopyN(buf, r, int64(arg))
+		return noEOF(err)
+
+	case 4, 5: // arrays & maps
+		if arg > maxCBORItemLength {
+			return fmt.Errorf("cbor: container too long: %d", arg)
+		}
+		items := arg
+		if major == 5 {
+			items *= 2
+		}
+		for i := uint64(0); i < items; i++ {
+			err := readCBORItemInto(r, buf, depth+1)
+			if err != nil {
+				return noEOF(err)
+			}
+		}
+		return nil
+
+	case 6: // tags
+		return noEOF(readCBORItemInto(r, buf, depth+1))
+
+	default: // integers, simple values & floats
+		return nil
+	}
+}
+
+// readCBORIndefinite copies the items of an indefinite length string,
+// array or map up to and including the break stop code.
+func readCBORIndefinite(r io.Reader, buf *bytes.Buffer, major byte, depth int) error {
+	if major < 2 || major > 5 {
+		return fmt.Errorf("cbor: invalid indefinite length for major type %d", major)
+	}
+
+	for {
+		var b [1]byte
+		_, err := io.ReadFull(r, b[:])
+		if err != nil {
+			return noEOF(err)
+		}
+		if b[0] == 0xff {
+			buf.WriteByte(b[0])
+			return nil
+		}
+
+		err = readCBORItemFrom(r, buf, b[0], depth+1)
+		if err != nil {
+			return noEOF(err)
+		}
+	}
+}
+
+// noEOF turns an EOF in the middle of an item into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
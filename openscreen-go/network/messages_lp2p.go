@@ -21,9 +21,12 @@ const (
 // (cddlc) Ident: data-channel-open-request
 type msgDataChannelOpenRequest struct {
 	msgRequest
-	ChannelId uint64 `cbor:"1,keyasint"`
-	Label     string `cbor:"2,keyasint"`
-	Protocol  string `cbor:"3,keyasint"`
+	ChannelId         uint64  `cbor:"1,keyasint"`
+	Label             string  `cbor:"2,keyasint"`
+	Protocol          string  `cbor:"3,keyasint"`
+	Unordered         bool    `cbor:"4,keyasint,omitempty"`
+	MaxRetransmits    *uint64 `cbor:"5,keyasint,omitempty"`
+	MaxPacketLifeTime *uint64 `cbor:"6,keyasint,omitempty"`
 }
 
 // (cddlc) Ident: data-channel-open-response
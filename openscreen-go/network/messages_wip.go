@@ -12,6 +12,7 @@ import "fmt"
 const (
 	// Transport Stream
 	typeKeyAuthSpake2NeedPskDeprecated TypeKey = 99001
+	typeKeyAuthKnownPeer               TypeKey = 99002
 )
 
 // auth-spake2-need-psk
@@ -19,6 +20,13 @@ type msgAuthSpake2NeedPskDeprecated struct {
 	AuthInitiationToken string `cbor:"0,keyasint"`
 }
 
+// auth-known-peer is sent before auth-capabilities by an agent that trusts
+// the remote agent from a previous authentication. If both agents trust
+// each other, the PSK authentication is skipped.
+type msgAuthKnownPeer struct {
+	Fingerprint string `cbor:"0,keyasint"`
+}
+
 // DataChannel
 
 // DataEncoding represents pre-agreed EncodingIds used in exchange-data.
@@ -30,6 +38,11 @@ const (
 	DataEncodingArrayBuffer
 )
 
+const (
+	typeKeyDataChannelMessage    TypeKey = 1103
+	typeKeyDataChannelNegotiated TypeKey = 1104
+)
+
 // WebTransport Pooled
 
 const (
@@ -38,10 +51,17 @@ const (
 	typeKeyDataTransportStartResponse  TypeKey = 1202
 	typeKeyDataTransportStreamRequest  TypeKey = 1203
 	typeKeyDataTransportStreamResponse TypeKey = 1204
+	typeKeyDataTransportClose          TypeKey = 1205
 )
 
 func newMessageByTypeWIP(key TypeKey) (interface{}, error) {
 	switch key {
+	case typeKeyDataChannelMessage:
+		return &msgDataChannelMessage{}, nil
+
+	case typeKeyDataChannelNegotiated:
+		return &msgDataChannelNegotiated{}, nil
+
 	case typeKeyDataTransportStartRequest:
 		return &msgDataTransportStartRequest{}, nil
 
@@ -54,9 +74,15 @@ func newMessageByTypeWIP(key TypeKey) (interface{}, error) {
 	case typeKeyDataTransportStreamResponse:
 		return &msgDataTransportStreamResponse{}, nil
 
+	case typeKeyDataTransportClose:
+		return &msgDataTransportClose{}, nil
+
 	case typeKeyAuthSpake2NeedPskDeprecated:
 		return &msgAuthSpake2NeedPskDeprecated{}, nil
 
+	case typeKeyAuthKnownPeer:
+		return &msgAuthKnownPeer{}, nil
+
 	default:
 		return nil, fmt.Errorf("unknown type key: %d", key)
 	}
@@ -64,6 +90,12 @@ func newMessageByTypeWIP(key TypeKey) (interface{}, error) {
 
 func typeKeyByMessageWIP(msg interface{}) (TypeKey, error) {
 	switch msg.(type) {
+	case *msgDataChannelMessage:
+		return typeKeyDataChannelMessage, nil
+
+	case *msgDataChannelNegotiated:
+		return typeKeyDataChannelNegotiated, nil
+
 	case *msgDataTransportStartRequest:
 		return typeKeyDataTransportStartRequest, nil
 
@@ -76,14 +108,35 @@ func typeKeyByMessageWIP(msg interface{}) (TypeKey, error) {
 	case *msgDataTransportStreamResponse:
 		return typeKeyDataTransportStreamResponse, nil
 
+	case *msgDataTransportClose:
+		return typeKeyDataTransportClose, nil
+
 	case *msgAuthSpake2NeedPskDeprecated:
 		return typeKeyAuthSpake2NeedPskDeprecated, nil
 
+	case *msgAuthKnownPeer:
+		return typeKeyAuthKnownPeer, nil
+
 	default:
 		return 0, fmt.Errorf("unknown message type: %T", msg)
 	}
 }
 
+// data-channel-message is sent on a stream of its own for each message of
+// an unordered or partially reliable data channel. The receiver closes
+// the stream once the message is read.
+type msgDataChannelMessage struct {
+	ChannelId  uint64 `cbor:"0,keyasint"`
+	EncodingId uint64 `cbor:"1,keyasint"`
+	Payload    []byte `cbor:"2,keyasint"`
+}
+
+// data-channel-negotiated is sent by the dialing agent on the stream of a
+// negotiated data channel, binding it to the channel ID.
+type msgDataChannelNegotiated struct {
+	ChannelId uint64 `cbor:"0,keyasint"`
+}
+
 // data-transport-start-request
 type msgDataTransportStartRequest struct {
 	RequestID  uint64 `cbor:"0,keyasint"`
@@ -97,9 +150,12 @@ type msgDataTransportStartResponse struct {
 }
 
 // data-transport-stream-request
+// A unidirectional stream is only written by the requester once the
+// response is received.
 type msgDataTransportStreamRequest struct {
-	RequestID  uint64 `cbor:"0,keyasint"`
-	ExchangeId uint64 `cbor:"1,keyasint"`
+	RequestID      uint64 `cbor:"0,keyasint"`
+	ExchangeId     uint64 `cbor:"1,keyasint"`
+	Unidirectional bool   `cbor:"2,keyasint,omitempty"`
 }
 
 // data-transport-stream-response
@@ -107,3 +163,11 @@ type msgDataTransportStreamResponse struct {
 	RequestID uint64    `cbor:"0,keyasint"`
 	Result    msgResult `cbor:"1,keyasint"`
 }
+
+// data-transport-close is sent when a pooled transport is closed. The
+// remote agent resets the streams of the transport.
+type msgDataTransportClose struct {
+	ExchangeId uint64 `cbor:"0,keyasint"`
+	Code       uint64 `cbor:"1,keyasint"`
+	Reason     string `cbor:"2,keyasint"`
+}
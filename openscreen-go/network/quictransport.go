@@ -1,14 +1,71 @@
 package ospc
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+
+	"github.com/quic-go/quic-go"
 )
 
 // PooledWebTransport implements WebTransport pooled over an
-// existing OpenScreenProtocol Application Transport.
+// existing OpenScreenProtocol Application Transport. Multiple sessions
+// can share a connection, streams are matched to a session by the
+// ExchangeId of the data-transport-stream-request.
 type PooledWebTransport struct {
-	conn *baseConnection
+	conn       *baseConnection
+	exchangeID uint64
+
+	accept    chan *baseStream
+	acceptUni chan *baseStream
+	datagrams chan []byte
+
+	// ctx is cancelled when the session is closed, its cause is the
+	// reason. See Context.
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	streams map[*baseStream]*sessionStream // Open streams, reset on close
+}
+
+// sessionStream tracks which directions of a session stream are done.
+// The stream stays open until both are.
+type sessionStream struct {
+	readDone  bool
+	writeDone bool
+}
+
+// datagramBacklog is the number of datagrams queued for a pooled
+// transport before further datagrams are dropped.
+const datagramBacklog = 64
+
+// SessionError is the cause of a WebTransport session that was closed by
+// either end with a code and reason.
+type SessionError struct {
+	Remote bool
+	Code   uint64
+	Reason string
+}
+
+func (e *SessionError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("session closed by peer: %d %s", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("session closed: %d %s", e.Code, e.Reason)
+}
+
+// CloseCode returns the application code the session was closed with.
+func (e *SessionError) CloseCode() uint64 {
+	return e.Code
+}
+
+// CloseReason returns the reason the session was closed with.
+func (e *SessionError) CloseReason() string {
+	return e.Reason
 }
 
 // NewTransport
@@ -18,26 +75,37 @@ func (c *Connection) NewTransport(ctx context.Context) (*PooledWebTransport, err
 
 func (c *baseConnection) NewTransport(ctx context.Context) (*PooledWebTransport, error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	msg := &msgDataTransportStartRequest{
-		RequestID: c.agentState.nextRequestID(),
-	}
-
-	stream, err := c.connectedState.appConn.OpenStreamSync(ctx)
+	requestID := c.agentState.nextRequestID()
+	exchangeID := c.connectedState.nextExchangeID
+	c.connectedState.nextExchangeID += 2
+	appConn := c.connectedState.appConn
+	// Registered up front, the remote agent can open streams as soon
+	// as it accepted the transport.
+	t, err := c.createDataTransport(exchangeID)
+	c.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
 
-	err = writeMessage(msg, stream)
+	msg := &msgDataTransportStartRequest{
+		RequestID:  requestID,
+		ExchangeId: exchangeID,
+	}
+	res, err := c.request(ctx, appConn, requestID, msg)
 	if err != nil {
+		c.removeDataTransport(t)
 		return nil, err
 	}
+	res.stream.Close()
 
-	// TODO: await data-exchange-start-response
-
-	t, err := c.createDataTransport()
+	startRes, ok := res.msg.(*msgDataTransportStartResponse)
+	if !ok {
+		c.removeDataTransport(t)
+		return nil, fmt.Errorf("unexpected response: %T", res.msg)
+	}
+	err = resultError(startRes.Result)
 	if err != nil {
+		c.removeDataTransport(t)
 		return nil, err
 	}
 
@@ -46,23 +114,32 @@ func (c *baseConnection) NewTransport(ctx context.Context) (*PooledWebTransport,
 
 type TransportListener struct {
 	conn *baseConnection
+
+	close     chan struct{}
+	closeOnce sync.Once
 }
 
-func (c *Connection) NewTransportListener() (*PooledWebTransport, error) {
-	return c.base.createDataTransport()
+func (c *Connection) NewTransportListener() (*TransportListener, error) {
+	return c.base.NewTransportListener()
 }
 
-func (c *baseConnection) NewTransportListener() (*PooledWebTransport, error) {
-	t, err := c.createDataTransport()
-	return t, err
+func (c *baseConnection) NewTransportListener() (*TransportListener, error) {
+	return &TransportListener{
+		conn:  c,
+		close: make(chan struct{}),
+	}, nil
 }
 
 func (l *TransportListener) Accept(ctx context.Context) (*PooledWebTransport, error) {
-	return l.conn.AcceptTransport(ctx)
+	return l.conn.acceptTransport(ctx, l.close)
 }
 
+// Close stops accepting transports. Transports that were accepted
+// already are not affected.
 func (l *TransportListener) Close() error {
-	// TODO: NOP for now
+	l.closeOnce.Do(func() {
+		close(l.close)
+	})
 	return nil
 }
 
@@ -71,6 +148,11 @@ func (c *Connection) AcceptTransport(ctx context.Context) (*PooledWebTransport,
 }
 
 func (c *baseConnection) AcceptTransport(ctx context.Context) (*PooledWebTransport, error) {
+	return c.acceptTransport(ctx, nil)
+}
+
+// acceptTransport accepts a transport until listenerClose is closed.
+func (c *baseConnection) acceptTransport(ctx context.Context, listenerClose <-chan struct{}) (*PooledWebTransport, error) {
 	c.mu.Lock()
 	close := c.close
 	accept := c.connectedState.acceptTransport
@@ -81,101 +163,498 @@ func (c *baseConnection) AcceptTransport(ctx context.Context) (*PooledWebTranspo
 		return nil, ctx.Err()
 	case <-close:
 		return nil, c.err()
+	case <-listenerClose:
+		return nil, ErrListenerClosed
 	case dc := <-accept:
 		return dc, nil
 	}
 }
 
-func (t *PooledWebTransport) AcceptStream(ctx context.Context) (*QuicStream, error) {
-	s, err := t.conn.AcceptTransportStream(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return s, err
+// ExchangeID returns the ID of the session on the connection.
+func (t *PooledWebTransport) ExchangeID() uint64 {
+	return t.exchangeID
 }
 
-func (c *baseConnection) AcceptTransportStream(ctx context.Context) (*QuicStream, error) {
-	c.mu.Lock()
-	close := c.close
-	accept := c.connectedState.acceptTransportStream
-	c.mu.Unlock()
+// Context returns a context that is cancelled when the session is
+// closed. Its cause is a *SessionError if the session was closed by
+// either end, or the connection error otherwise.
+func (t *PooledWebTransport) Context() context.Context {
+	return t.ctx
+}
 
+func (t *PooledWebTransport) AcceptStream(ctx context.Context) (*QuicStream, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case <-close:
-		return nil, c.err()
-	case s := <-accept:
+	case <-t.ctx.Done():
+		return nil, context.Cause(t.ctx)
+	case s := <-t.accept:
 		return &QuicStream{
-			stream: s,
+			stream:  s,
+			session: t,
+		}, nil
+	}
+}
+
+// AcceptUniStream accepts a unidirectional stream opened by the remote
+// agent.
+func (t *PooledWebTransport) AcceptUniStream(ctx context.Context) (*QuicReceiveStream, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.ctx.Done():
+		return nil, context.Cause(t.ctx)
+	case s := <-t.acceptUni:
+		return &QuicReceiveStream{
+			stream:  s,
+			session: t,
 		}, nil
 	}
 }
 
+// deliverStream hands an incoming stream to the session.
+func (t *PooledWebTransport) deliverStream(s *baseStream, unidirectional bool) error {
+	// An incoming unidirectional stream has no write direction.
+	if !t.track(s, &sessionStream{writeDone: unidirectional}) {
+		// The session was closed in the meantime.
+		return nil
+	}
+
+	accept := t.accept
+	if unidirectional {
+		accept = t.acceptUni
+	}
+
+	select {
+	case <-t.ctx.Done():
+		// Reset by shutdown
+		return nil
+	case accept <- s:
+		return nil
+	}
+}
+
 func (t *PooledWebTransport) OpenStreamSync(ctx context.Context) (*QuicStream, error) {
-	s, err := t.conn.OpenTransportStream(ctx)
+	s, err := t.openStream(ctx, false)
 	if err != nil {
 		return nil, err
 	}
 
 	return &QuicStream{
-		stream: s,
+		stream:  s,
+		session: t,
+	}, nil
+}
+
+// OpenUniStreamSync opens a unidirectional stream to the remote agent.
+func (t *PooledWebTransport) OpenUniStreamSync(ctx context.Context) (*QuicSendStream, error) {
+	s, err := t.openStream(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuicSendStream{
+		stream:  s,
+		session: t,
 	}, nil
 }
 
-func (c *baseConnection) OpenTransportStream(ctx context.Context) (*baseStream, error) {
+func (t *PooledWebTransport) openStream(ctx context.Context, unidirectional bool) (*baseStream, error) {
+	if t.ctx.Err() != nil {
+		return nil, context.Cause(t.ctx)
+	}
+
+	s, err := t.conn.OpenTransportStream(ctx, t.exchangeID, unidirectional)
+	if err != nil {
+		return nil, err
+	}
+	// An outgoing unidirectional stream has no read direction.
+	if !t.track(s, &sessionStream{readDone: unidirectional}) {
+		return nil, context.Cause(t.ctx)
+	}
+	return s, nil
+}
+
+// track adds a stream to the session. If the session is closed already,
+// the stream is reset and false is returned.
+func (t *PooledWebTransport) track(s *baseStream, state *sessionStream) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.streams == nil {
+		_ = s.stream.Reset()
+		return false
+	}
+	t.streams[s] = state
+	return true
+}
+
+// finish marks directions of a stream as done. The stream is removed from
+// the session once both directions are done, e.g., the write direction is
+// closed and the read direction reached EOF.
+func (t *PooledWebTransport) finish(s *baseStream, read, write bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.streams[s]
+	if !ok {
+		return
+	}
+	state.readDone = state.readDone || read
+	state.writeDone = state.writeDone || write
+	if state.readDone && state.writeDone {
+		delete(t.streams, s)
+	}
+}
+
+func (c *baseConnection) OpenTransportStream(ctx context.Context, exchangeID uint64, unidirectional bool) (*baseStream, error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	requestID := c.agentState.nextRequestID()
+	appConn := c.connectedState.appConn
+	c.mu.Unlock()
 
 	msg := &msgDataTransportStreamRequest{
-		RequestID: c.agentState.nextRequestID(),
+		RequestID:      requestID,
+		ExchangeId:     exchangeID,
+		Unidirectional: unidirectional,
+	}
+	res, err := c.request(ctx, appConn, requestID, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	streamRes, ok := res.msg.(*msgDataTransportStreamResponse)
+	if !ok {
+		res.stream.Close()
+		return nil, fmt.Errorf("unexpected response: %T", res.msg)
+	}
+	err = resultError(streamRes.Result)
+	if err != nil {
+		res.stream.Close()
+		return nil, err
+	}
+
+	return newBaseStream(res.stream, nil), nil
+}
+
+// SendDatagram sends an unreliable datagram. It is prefixed with the
+// ExchangeId so the remote agent can match it to the session.
+func (t *PooledWebTransport) SendDatagram(p []byte) error {
+	if t.ctx.Err() != nil {
+		return context.Cause(t.ctx)
+	}
+	dgConn, ok := t.conn.datagramConnection()
+	if !ok {
+		return ErrDatagramsUnsupported
+	}
+	if len(p) > t.MaxDatagramSize() {
+		return ErrDatagramTooLarge
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, varuintLen(t.exchangeID)+len(p)))
+	err := writeVaruint(t.exchangeID, buf)
+	if err != nil {
+		return err
+	}
+	buf.Write(p)
+
+	return dgConn.SendDatagram(buf.Bytes())
+}
+
+// ReceiveDatagram receives a datagram sent by the remote agent.
+func (t *PooledWebTransport) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.ctx.Done():
+		return nil, context.Cause(t.ctx)
+	case p := <-t.datagrams:
+		return p, nil
+	}
+}
+
+// MaxDatagramSize returns the largest datagram that can be sent, or 0
+// if the connection doesn't support datagrams.
+func (t *PooledWebTransport) MaxDatagramSize() int {
+	dgConn, ok := t.conn.datagramConnection()
+	if !ok {
+		return 0
+	}
+	return dgConn.MaxDatagramSize() - varuintLen(t.exchangeID)
+}
+
+// Stats returns the statistics of the connection. They include the
+// traffic of all sessions on the connection.
+func (t *PooledWebTransport) Stats() (ConnectionStats, error) {
+	t.conn.mu.Lock()
+	statsConn, ok := t.conn.connectedState.appConn.(StatsConnection)
+	t.conn.mu.Unlock()
+	if !ok {
+		return ConnectionStats{}, ErrStatsUnsupported
+	}
+	return statsConn.Stats()
+}
+
+// deliverDatagram queues an incoming datagram. It is dropped if the
+// application doesn't keep up.
+func (t *PooledWebTransport) deliverDatagram(p []byte) {
+	select {
+	case t.datagrams <- p:
+	default:
+	}
+}
+
+// CloseWithError closes the session and resets its streams. The code and
+// reason are sent to the remote agent. Other sessions on the same
+// connection are not affected.
+func (t *PooledWebTransport) CloseWithError(code uint64, reason string) error {
+	if !t.shutdown(&SessionError{Code: code, Reason: reason}) {
+		return nil
+	}
+
+	return t.conn.sendDataTransportClose(t.exchangeID, code, reason)
+}
+
+// shutdown closes the session with the given cause. It returns false if
+// the session was closed already.
+func (t *PooledWebTransport) shutdown(cause error) bool {
+	closed := false
+	t.closeOnce.Do(func() {
+		closed = true
+		t.conn.removeDataTransport(t)
+		t.cancel(cause)
+
+		t.mu.Lock()
+		streams := t.streams
+		t.streams = nil
+		t.mu.Unlock()
+
+		for s := range streams {
+			_ = s.stream.Reset()
+		}
+	})
+	return closed
+}
+
+// DedicatedWebTransport implements WebTransport over a dedicated QUIC
+// connection, see Connection.DialApplication and ALPNListener.
+type DedicatedWebTransport struct {
+	conn  quic.Connection
+	ctx   context.Context
+	stats *quicStatsTracer
+}
+
+func NewDedicatedWebTransport(conn quic.Connection) *DedicatedWebTransport {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	context.AfterFunc(conn.Context(), func() {
+		cause := context.Cause(conn.Context())
+		var appErr *quic.ApplicationError
+		if errors.As(cause, &appErr) {
+			cause = &SessionError{
+				Remote: appErr.Remote,
+				Code:   uint64(appErr.ErrorCode),
+				Reason: appErr.ErrorMessage,
+			}
+		}
+		cancel(cause)
+	})
+
+	return &DedicatedWebTransport{
+		conn:  conn,
+		ctx:   ctx,
+		stats: quicConnectionStats(conn),
 	}
+}
 
-	stream, err := c.connectedState.appConn.OpenStreamSync(ctx)
+// Context returns a context that is cancelled when the connection is
+// closed. Its cause is a *SessionError if the connection was closed by
+// either end with an application code, or the connection error otherwise.
+func (t *DedicatedWebTransport) Context() context.Context {
+	return t.ctx
+}
+
+func (t *DedicatedWebTransport) AcceptStream(ctx context.Context) (*QuicStream, error) {
+	s, err := t.conn.AcceptStream(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	err = writeMessage(msg, stream)
+	return &QuicStream{
+		stream: newBaseStream(&QuicApplicationStream{stream: s}, nil),
+	}, nil
+}
+
+func (t *DedicatedWebTransport) OpenStreamSync(ctx context.Context) (*QuicStream, error) {
+	s, err := t.conn.OpenStreamSync(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: await data-exchange-start-response
+	return &QuicStream{
+		stream: newBaseStream(&QuicApplicationStream{stream: s}, nil),
+	}, nil
+}
 
-	return newBaseStream(stream, nil), nil
+func (t *DedicatedWebTransport) AcceptUniStream(ctx context.Context) (*QuicReceiveStream, error) {
+	s, err := t.conn.AcceptUniStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuicReceiveStream{
+		stream: newBaseStream(&QuicApplicationReceiveStream{stream: s}, nil),
+	}, nil
 }
 
-func (t *PooledWebTransport) CloseWithError(uint64, string) error {
-	// TODO: NOP for now
-	return nil
+func (t *DedicatedWebTransport) OpenUniStreamSync(ctx context.Context) (*QuicSendStream, error) {
+	s, err := t.conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuicSendStream{
+		stream: newBaseStream(&QuicApplicationSendStream{stream: s}, nil),
+	}, nil
+}
+
+func (t *DedicatedWebTransport) SendDatagram(p []byte) error {
+	return sendQuicDatagram(t.conn, p)
+}
+
+func (t *DedicatedWebTransport) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return t.conn.ReceiveMessage(ctx)
+}
+
+// MaxDatagramSize returns the largest datagram that can be sent, or 0
+// if the remote agent doesn't support datagrams.
+func (t *DedicatedWebTransport) MaxDatagramSize() int {
+	if !t.conn.ConnectionState().SupportsDatagrams {
+		return 0
+	}
+	return quicMaxDatagramSize
+}
+
+// Stats returns the statistics of the connection.
+func (t *DedicatedWebTransport) Stats() (ConnectionStats, error) {
+	return t.stats.Stats()
+}
+
+func (t *DedicatedWebTransport) CloseWithError(code uint64, reason string) error {
+	return t.conn.CloseWithError(quic.ApplicationErrorCode(code), reason)
+}
+
+// RemotePeerID returns the PeerID of the remote agent.
+func (t *DedicatedWebTransport) RemotePeerID() (PeerID, error) {
+	certs := t.conn.ConnectionState().TLS.PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("no peer certificate")
+	}
+	return CertificatePeerID(certs[0])
 }
 
 // Stream
 type QuicStream struct {
-	stream *baseStream
+	stream  *baseStream
+	session *PooledWebTransport // nil for dedicated transports
 }
 
 func (s *QuicStream) StreamID() int64 {
-	switch stream := s.stream.stream.(type) {
-	case *QuicApplicationStream:
-		return int64(stream.stream.StreamID())
-
-	default:
-		// TODO: move to transport interface?
-		panic(fmt.Sprintf("unknown stream type: %T", s.stream.stream))
-	}
+	return s.stream.stream.StreamID()
 }
 
 func (s *QuicStream) Read(p []byte) (int, error) {
 	n, err := s.stream.stream.Read(p)
 	// fmt.Printf("QuicStream.Read: %d %s %v", n, string(p[:n]), err)
+	if err != nil && s.session != nil {
+		// The read direction is done
+		s.session.finish(s.stream, true, false)
+	}
 	return n, err
 }
 
 func (s *QuicStream) Write(p []byte) (n int, err error) {
-	return s.stream.stream.Write(p)
+	n, err = s.stream.stream.Write(p)
+	if err != nil && s.session != nil {
+		// The write direction is done
+		s.session.finish(s.stream, false, true)
+	}
+	return n, err
 }
+
+// Close closes the write direction of the stream. The stream stays part of
+// the session until the read direction is done as well.
 func (s *QuicStream) Close() error {
+	if s.session != nil {
+		s.session.finish(s.stream, false, true)
+	}
+	return s.stream.stream.Close()
+}
+
+// Reset aborts both directions of the stream.
+func (s *QuicStream) Reset() error {
+	if s.session != nil {
+		s.session.finish(s.stream, true, true)
+	}
+	return s.stream.stream.Reset()
+}
+
+// QuicSendStream is the sending end of a unidirectional stream.
+type QuicSendStream struct {
+	stream  *baseStream
+	session *PooledWebTransport // nil for dedicated transports
+}
+
+func (s *QuicSendStream) StreamID() int64 {
+	return s.stream.stream.StreamID()
+}
+
+func (s *QuicSendStream) Write(p []byte) (n int, err error) {
+	n, err = s.stream.stream.Write(p)
+	if err != nil && s.session != nil {
+		s.session.finish(s.stream, false, true)
+	}
+	return n, err
+}
+
+// Close ends the stream.
+func (s *QuicSendStream) Close() error {
+	if s.session != nil {
+		s.session.finish(s.stream, false, true)
+	}
 	return s.stream.stream.Close()
 }
+
+// Reset aborts the stream.
+func (s *QuicSendStream) Reset() error {
+	if s.session != nil {
+		s.session.finish(s.stream, true, true)
+	}
+	return s.stream.stream.Reset()
+}
+
+// QuicReceiveStream is the receiving end of a unidirectional stream.
+type QuicReceiveStream struct {
+	stream  *baseStream
+	session *PooledWebTransport // nil for dedicated transports
+}
+
+func (s *QuicReceiveStream) StreamID() int64 {
+	return s.stream.stream.StreamID()
+}
+
+func (s *QuicReceiveStream) Read(p []byte) (int, error) {
+	n, err := s.stream.stream.Read(p)
+	if err != nil && s.session != nil {
+		// The stream is done
+		s.session.finish(s.stream, true, false)
+	}
+	return n, err
+}
+
+// Reset stops reading from the stream.
+func (s *QuicReceiveStream) Reset() error {
+	if s.session != nil {
+		s.session.finish(s.stream, true, true)
+	}
+	return s.stream.stream.Reset()
+}
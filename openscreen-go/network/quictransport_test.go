@@ -0,0 +1,381 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPooledWebTransportSessions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	// Sessions started by both sides.
+	one, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteOne, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := aConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteTwo, err := dConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.ExchangeID() == two.ExchangeID() {
+		t.Fatalf("sessions share exchange ID %d", one.ExchangeID())
+	}
+	if remoteOne.ExchangeID() != one.ExchangeID() || remoteTwo.ExchangeID() != two.ExchangeID() {
+		t.Fatal("exchange ID mismatch")
+	}
+
+	sendStream := func(from *PooledWebTransport, data string) {
+		t.Helper()
+		s, err := from.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	acceptStream := func(to *PooledWebTransport) string {
+		t.Helper()
+		s, err := to.AcceptStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// Streams are delivered to their own session, regardless of the
+	// order in which the sessions accept.
+	sendStream(two, "two")
+	sendStream(one, "one")
+	if data := acceptStream(remoteOne); data != "one" {
+		t.Fatalf("unexpected stream on session one: %s", data)
+	}
+	if data := acceptStream(remoteTwo); data != "two" {
+		t.Fatalf("unexpected stream on session two: %s", data)
+	}
+
+	// Closing a session doesn't affect the others.
+	err = one.CloseWithError(0, "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = one.OpenStreamSync(ctx)
+	if err == nil {
+		t.Fatal("expected error opening a stream on a closed session")
+	}
+	sendStream(two, "still two")
+	if data := acceptStream(remoteTwo); data != "still two" {
+		t.Fatalf("unexpected stream on session two: %s", data)
+	}
+}
+
+func TestPooledWebTransportClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	wt, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := wt.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := remote.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wt.CloseWithError(42, "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The close info reaches the remote agent.
+	select {
+	case <-remote.Context().Done():
+	case <-ctx.Done():
+		t.Fatal("remote session not closed")
+	}
+	var sessErr *SessionError
+	if !errors.As(context.Cause(remote.Context()), &sessErr) {
+		t.Fatalf("unexpected cause: %v", context.Cause(remote.Context()))
+	}
+	if !sessErr.Remote || sessErr.Code != 42 || sessErr.Reason != "bye" {
+		t.Fatalf("unexpected close info: %+v", sessErr)
+	}
+	if !errors.As(context.Cause(wt.Context()), &sessErr) || sessErr.Remote {
+		t.Fatalf("unexpected local cause: %v", context.Cause(wt.Context()))
+	}
+
+	// Streams are reset on both ends.
+	_, err = io.ReadAll(rs)
+	if !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("expected remote stream reset, got: %v", err)
+	}
+	_, err = s.Write([]byte("pong"))
+	if !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("expected local stream reset, got: %v", err)
+	}
+	_, err = remote.AcceptStream(ctx)
+	if !errors.As(err, &sessErr) {
+		t.Fatalf("expected session error, got: %v", err)
+	}
+
+	// A closed listener stops accepting.
+	l, err := aConn.NewTransportListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Accept(ctx)
+	if !errors.Is(err, ErrListenerClosed) {
+		t.Fatalf("expected listener closed, got: %v", err)
+	}
+}
+
+func TestPooledWebTransportHalfClosedStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	wt, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracked := func(p *PooledWebTransport) int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.streams)
+	}
+
+	// open sends ping on a new stream and closes its write direction.
+	open := func() (*QuicStream, *QuicStream) {
+		s, err := wt.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Write([]byte("ping"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, err := remote.AcceptStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "ping" {
+			t.Fatalf("unexpected stream data: %s", data)
+		}
+		return s, rs
+	}
+
+	// A stream stays part of the session until both directions are done.
+	s, rs := open()
+	if tracked(wt) != 1 || tracked(remote) != 1 {
+		t.Fatalf("half-closed stream untracked: %d, %d", tracked(wt), tracked(remote))
+	}
+	_, err = rs.Write([]byte("pong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rs.Close()
+	data, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "pong" {
+		t.Fatalf("unexpected stream data: %s", data)
+	}
+	if tracked(wt) != 0 || tracked(remote) != 0 {
+		t.Fatalf("closed stream still tracked: %d, %d", tracked(wt), tracked(remote))
+	}
+
+	// Closing the session resets half-closed streams.
+	s, _ = open()
+	err = wt.CloseWithError(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(s)
+	if !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("expected stream reset, got: %v", err)
+	}
+}
+
+func TestPooledWebTransportUniStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	wt, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bidirectional and unidirectional streams are accepted separately.
+	_, err = wt.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := wt.OpenUniStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Write([]byte("telemetry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := remote.AcceptUniStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "telemetry" {
+		t.Fatalf("unexpected stream data: %s", data)
+	}
+
+	_, err = remote.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPooledWebTransportDatagrams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dConn, aConn := newLoopbackConnections(ctx, t)
+
+	one, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteOne, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteTwo, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Datagrams are delivered to their own session.
+	err = two.SendDatagram([]byte("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = one.SendDatagram([]byte("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := remoteOne.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "one" {
+		t.Fatalf("unexpected datagram on session one: %s", p)
+	}
+	p, err = remoteTwo.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "two" {
+		t.Fatalf("unexpected datagram on session two: %s", p)
+	}
+
+	// The session ID is accounted for in the max size.
+	max := one.MaxDatagramSize()
+	if max <= 0 || max >= quicMaxDatagramSize {
+		t.Fatalf("unexpected max datagram size: %d", max)
+	}
+	err = one.SendDatagram(make([]byte, max+1))
+	if !errors.Is(err, ErrDatagramTooLarge) {
+		t.Fatalf("expected datagram too large, got: %v", err)
+	}
+	err = one.SendDatagram(make([]byte, max))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = remoteOne.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != max {
+		t.Fatalf("unexpected datagram length: %d", len(p))
+	}
+
+	// The loopback transport doesn't keep stats.
+	_, err = one.Stats()
+	if !errors.Is(err, ErrStatsUnsupported) {
+		t.Fatalf("expected stats unsupported, got: %v", err)
+	}
+}
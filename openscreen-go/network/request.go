@@ -0,0 +1,176 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ResultError is returned when the remote agent responds to a request
+// with a result other than success. It can also be returned by a
+// DataChannelPolicy to refuse a data channel with a specific result.
+type ResultError struct {
+	Result msgResult
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("request failed: %s", e.Result)
+}
+
+func (r msgResult) String() string {
+	switch r {
+	case ResultSuccess:
+		return "success"
+	case ResultInvalidUrl:
+		return "invalid-url"
+	case ResultInvalidPresentationId:
+		return "invalid-presentation-id"
+	case ResultTimeout:
+		return "timeout"
+	case ResultTransientError:
+		return "transient-error"
+	case ResultPermanentError:
+		return "permanent-error"
+	case ResultTerminating:
+		return "terminating"
+	case ResultUnknownError:
+		return "unknown-error"
+	default:
+		return fmt.Sprintf("result(%d)", uint64(r))
+	}
+}
+
+// resultError turns a result into an error. It returns nil on success.
+func resultError(r msgResult) error {
+	if r == ResultSuccess {
+		return nil
+	}
+	return &ResultError{Result: r}
+}
+
+// errorResult turns an error into a result, see resultError.
+func errorResult(err error) msgResult {
+	if err == nil {
+		return ResultSuccess
+	}
+	var resErr *ResultError
+	if errors.As(err, &resErr) {
+		return resErr.Result
+	}
+	return ResultPermanentError
+}
+
+// requestTracker correlates responses with outstanding requests
+// by request ID.
+type requestTracker struct {
+	mu      sync.Mutex
+	pending map[uint64]chan interface{}
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{
+		pending: make(map[uint64]chan interface{}),
+	}
+}
+
+// register starts tracking a request. It should be called before the
+// request is sent.
+func (t *requestTracker) register(id uint64) chan interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan interface{}, 1)
+	t.pending[id] = ch
+	return ch
+}
+
+// resolve delivers the response to a request. It returns false if
+// the request isn't tracked, e.g., because it timed out.
+func (t *requestTracker) resolve(id uint64, msg interface{}) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch, ok := t.pending[id]
+	if !ok {
+		return false
+	}
+	delete(t.pending, id)
+	ch <- msg
+	return true
+}
+
+func (t *requestTracker) cancel(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, id)
+}
+
+// response is the response to a request and the stream it was received
+// on. The stream can be used further by the requester.
+type response struct {
+	msg    interface{}
+	stream ApplicationStream
+}
+
+// request sends msg on a new stream and waits for the response on the
+// same stream.
+func (c *baseConnection) request(ctx context.Context, appConn ApplicationConnection, id uint64, msg interface{}) (*response, error) {
+	stream, err := appConn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := c.requests.register(id)
+	err = c.writeMessage(msg, stream)
+	if err != nil {
+		c.requests.cancel(id)
+		_ = stream.Close()
+		return nil, err
+	}
+
+	// The stream is read until the response arrives.
+	c.handleApplicationStream(newBaseStream(stream, c.handleApplicationMessage))
+
+	res, err := c.awaitResponse(ctx, id, ch)
+	if err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+
+	return &response{
+		msg:    res,
+		stream: stream,
+	}, nil
+}
+
+// awaitResponse waits for the response to a registered request.
+func (c *baseConnection) awaitResponse(ctx context.Context, id uint64, ch chan interface{}) (interface{}, error) {
+	c.mu.Lock()
+	close := c.close
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		c.requests.cancel(id)
+		return nil, ctx.Err()
+	case <-close:
+		c.requests.cancel(id)
+		return nil, c.err()
+	case msg := <-ch:
+		return msg, nil
+	}
+}
+
+// handleResponse passes a response to the request it belongs to. The
+// stream isn't read any further, it is handed to the requester.
+func (c *baseConnection) handleResponse(id uint64, msg interface{}, stream *baseStream) error {
+	stream.SetHandler(nil)
+
+	if !c.requests.resolve(id, msg) {
+		c.log.Warnf("ignoring %T for unknown request %d", msg, id)
+		_ = stream.stream.Close()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOpenDataChannelResponse(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	config := NewAgentConfig("Listener")
+	config.DataChannelPolicy = func(remoteAgent *Agent, params DataChannelParameters) error {
+		switch params.Protocol {
+		case "chat":
+			return nil
+		case "slow":
+			<-release
+			return nil
+		default:
+			return &ResultError{Result: ResultInvalidUrl}
+		}
+	}
+	dConn, aConn := newLoopbackConnectionsWithConfig(ctx, t, config)
+
+	// Accepted
+	_, err := dConn.OpenDataChannel(ctx, DataChannelParameters{Label: "a", Protocol: "chat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = aConn.AcceptDataChannel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Refused
+	_, err = dConn.OpenDataChannel(ctx, DataChannelParameters{Label: "b", Protocol: "unknown"})
+	var resErr *ResultError
+	if !errors.As(err, &resErr) {
+		t.Fatalf("expected ResultError, got: %v", err)
+	}
+	if resErr.Result != ResultInvalidUrl {
+		t.Fatalf("unexpected result: %s", resErr.Result)
+	}
+
+	// Timed out
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer timeoutCancel()
+	_, err = dConn.OpenDataChannel(timeoutCtx, DataChannelParameters{Label: "c", Protocol: "slow"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got: %v", err)
+	}
+
+	// The connection is still usable.
+	_, err = dConn.OpenDataChannel(ctx, DataChannelParameters{Label: "d", Protocol: "chat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
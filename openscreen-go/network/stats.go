@@ -0,0 +1,154 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+var ErrStatsUnsupported = errors.New("stats not supported")
+
+// ConnectionStats are the statistics of a QUIC connection.
+type ConnectionStats struct {
+	BytesSent        uint64
+	PacketsSent      uint64
+	BytesReceived    uint64
+	PacketsReceived  uint64
+	PacketsLost      uint64
+	SmoothedRTT      time.Duration
+	RTTVariation     time.Duration
+	MinRTT           time.Duration
+	CongestionWindow uint64
+}
+
+// StatsConnection is implemented by application connections that
+// report statistics.
+type StatsConnection interface {
+	Stats() (ConnectionStats, error)
+}
+
+// quicStatsTracers holds the stats of the open QUIC connections by
+// tracing ID, until they're picked up by their connection.
+var (
+	quicStatsMu      sync.Mutex
+	quicStatsTracers = make(map[uint64]*quicStatsTracer)
+)
+
+// quicStatsTracer collects ConnectionStats from quic-go tracing events.
+type quicStatsTracer struct {
+	mu    sync.Mutex
+	stats ConnectionStats
+}
+
+// newQuicStatsTracer is the quic.Config.Tracer for all connections.
+func newQuicStatsTracer(ctx context.Context, _ logging.Perspective, _ quic.ConnectionID) *logging.ConnectionTracer {
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+
+	t := &quicStatsTracer{}
+	quicStatsMu.Lock()
+	quicStatsTracers[id] = t
+	quicStatsMu.Unlock()
+
+	return &logging.ConnectionTracer{
+		SentLongHeaderPacket: func(_ *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, _ []logging.Frame) {
+			t.sent(size)
+		},
+		SentShortHeaderPacket: func(_ *logging.ShortHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, _ []logging.Frame) {
+			t.sent(size)
+		},
+		ReceivedLongHeaderPacket: func(_ *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ []logging.Frame) {
+			t.received(size)
+		},
+		ReceivedShortHeaderPacket: func(_ *logging.ShortHeader, size logging.ByteCount, _ logging.ECN, _ []logging.Frame) {
+			t.received(size)
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.stats.PacketsLost++
+		},
+		UpdatedMetrics: func(rttStats *logging.RTTStats, cwnd, _ logging.ByteCount, _ int) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			t.stats.SmoothedRTT = rttStats.SmoothedRTT()
+			t.stats.RTTVariation = rttStats.MeanDeviation()
+			t.stats.MinRTT = rttStats.MinRTT()
+			t.stats.CongestionWindow = uint64(cwnd)
+		},
+		Close: func() {
+			quicStatsMu.Lock()
+			defer quicStatsMu.Unlock()
+
+			delete(quicStatsTracers, id)
+		},
+	}
+}
+
+func (t *quicStatsTracer) sent(size logging.ByteCount) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stats.PacketsSent++
+	t.stats.BytesSent += uint64(size)
+}
+
+func (t *quicStatsTracer) received(size logging.ByteCount) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stats.PacketsReceived++
+	t.stats.BytesReceived += uint64(size)
+}
+
+// Stats returns a snapshot of the stats.
+func (t *quicStatsTracer) Stats() (ConnectionStats, error) {
+	if t == nil {
+		return ConnectionStats{}, ErrStatsUnsupported
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.stats, nil
+}
+
+// quicConnectionStats returns the stats tracer of a connection, or nil
+// if the connection isn't traced.
+func quicConnectionStats(conn quic.Connection) *quicStatsTracer {
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+
+	quicStatsMu.Lock()
+	defer quicStatsMu.Unlock()
+
+	return quicStatsTracers[id]
+}
+
+// rtt returns the smoothed round-trip time of the connection, or
+// defaultRTT if it doesn't report one.
+func (c *baseConnection) rtt() time.Duration {
+	c.mu.Lock()
+	appConn := c.connectedState.appConn
+	c.mu.Unlock()
+
+	sc, ok := appConn.(StatsConnection)
+	if !ok {
+		return defaultRTT
+	}
+	stats, err := sc.Stats()
+	if err != nil || stats.SmoothedRTT == 0 {
+		return defaultRTT
+	}
+	return stats.SmoothedRTT
+}
@@ -7,18 +7,28 @@ import (
 	"fmt"
 	"io"
 	"net"
+
+	"github.com/pion/logging"
 )
 
 var ErrTransportClosed = errors.New("transport closed")
 var ErrTransportHandedOff = errors.New("transport handed off")
+var ErrStreamReset = errors.New("stream reset")
+var ErrSendOnlyStream = errors.New("send-only stream")
+var ErrReceiveOnlyStream = errors.New("receive-only stream")
+var ErrDatagramsUnsupported = errors.New("datagrams not supported")
+var ErrDatagramTooLarge = errors.New("datagram too large")
 
-func NewNetworkTransport(typ AgentTransport) (NetworkTransport, error) {
+func NewNetworkTransport(typ AgentTransport, loggerFactory logging.LoggerFactory) (NetworkTransport, error) {
 	switch typ {
 	case AgentTransportQUIC:
 		return &QuicTransport{}, nil
 
 	case AgentTransportWebRTC:
-		return &DTLSTransport{}, nil
+		return &DTLSTransport{loggerFactory: loggerFactory}, nil
+
+	case AgentTransportLoopback:
+		return &LoopbackTransport{}, nil
 
 	default:
 		return nil, fmt.Errorf("unknown transport type: %T", typ)
@@ -33,6 +43,7 @@ type NetworkTransport interface {
 type NetworkListener interface {
 	Accept(ctx context.Context) (NetworkConnection, error)
 	Addr() net.Addr
+	Close() error
 }
 
 // Abstract connection for the network protocol, responsible for getting
@@ -56,9 +67,24 @@ type ApplicationConnection interface {
 	Close() error
 }
 
+// DatagramConnection is implemented by application connections that
+// support unreliable, unordered datagrams.
+type DatagramConnection interface {
+	SendDatagram([]byte) error
+	ReceiveDatagram(context.Context) ([]byte, error)
+	// MaxDatagramSize is the largest payload that can be sent.
+	MaxDatagramSize() int
+}
+
 // Abstract stream for the application protocol.
 type ApplicationStream interface {
+	// Close closes the write direction of the stream.
 	io.ReadWriteCloser
+	// Reset aborts both directions of the stream. Buffered data is
+	// discarded.
+	Reset() error
+	// StreamID returns the transport level ID of the stream.
+	StreamID() int64
 }
 
 func listenUDP(addr string) (*net.UDPConn, error) {
@@ -0,0 +1,540 @@
+package ospc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// loopbackAcceptBacklog is the number of streams that can be opened
+// before the remote side accepts them.
+const loopbackAcceptBacklog = 64
+
+// loopbackDatagramBacklog is the number of datagrams that are queued
+// before further datagrams are dropped.
+const loopbackDatagramBacklog = 64
+
+var (
+	loopbackMu        sync.Mutex
+	loopbackNextPort  = 1
+	loopbackListeners = make(map[int]*LoopbackNetworkListener)
+)
+
+var _ NetworkTransport = &LoopbackTransport{}
+
+// LoopbackTransport is an in-process transport for agents in the same
+// process, e.g., in tests. Connections are made over in-memory pipes
+// instead of sockets. The TLS handshake is performed over a pipe so the
+// agents are authenticated in the same way as with the other transports.
+// Only the port of an address is used.
+type LoopbackTransport struct{}
+
+func NewLoopbackTransport() *LoopbackTransport {
+	return &LoopbackTransport{}
+}
+
+func (t *LoopbackTransport) DialAddr(ctx context.Context, addr string, tlsConf *tls.Config) (NetworkConnection, error) {
+	port, err := loopbackPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	loopbackMu.Lock()
+	l, ok := loopbackListeners[port]
+	loopbackMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("loopback: no listener on %s", addr)
+	}
+
+	clientPipe, serverPipe := net.Pipe()
+	client := tls.Client(clientPipe, tlsConf)
+	server := tls.Server(serverPipe, l.tlsConf)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.HandshakeContext(ctx)
+	}()
+
+	err = client.HandshakeContext(ctx)
+	if err != nil {
+		clientPipe.Close()
+		serverPipe.Close()
+		<-serverErr
+		return nil, fmt.Errorf("dial failed to handshake: %v", err)
+	}
+	err = <-serverErr
+	if err != nil {
+		clientPipe.Close()
+		serverPipe.Close()
+		return nil, fmt.Errorf("dial failed to handshake: %v", err)
+	}
+
+	// The pipes are only used for the handshake. Afterwards
+	// the endpoints exchange data directly.
+	clientState := client.ConnectionState()
+	serverState := server.ConnectionState()
+	clientPipe.Close()
+	serverPipe.Close()
+
+	clientEndpoint, serverEndpoint := newLoopbackEndpoints()
+
+	select {
+	case l.accept <- newLoopbackNetworkConnection(serverEndpoint, serverState):
+	case <-l.close:
+		return nil, fmt.Errorf("loopback: no listener on %s", addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return newLoopbackNetworkConnection(clientEndpoint, clientState), nil
+}
+
+func (t *LoopbackTransport) ListenAddr(addr string, tlsConf *tls.Config) (NetworkListener, error) {
+	port, err := loopbackPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	loopbackMu.Lock()
+	defer loopbackMu.Unlock()
+
+	if port == 0 {
+		for {
+			port = loopbackNextPort
+			loopbackNextPort++
+			if _, ok := loopbackListeners[port]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := loopbackListeners[port]; ok {
+		return nil, fmt.Errorf("loopback: port %d in use", port)
+	}
+
+	l := &LoopbackNetworkListener{
+		port:    port,
+		tlsConf: tlsConf,
+		accept:  make(chan NetworkConnection),
+		close:   make(chan struct{}),
+	}
+	loopbackListeners[port] = l
+
+	return l, nil
+}
+
+func loopbackPort(addr string) (int, error) {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	if portStr == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(portStr)
+}
+
+// loopbackAddr is the address of a LoopbackNetworkListener.
+type loopbackAddr int
+
+func (a loopbackAddr) Network() string {
+	return "loopback"
+}
+
+func (a loopbackAddr) String() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(int(a)))
+}
+
+var _ NetworkListener = &LoopbackNetworkListener{}
+
+type LoopbackNetworkListener struct {
+	port    int
+	tlsConf *tls.Config
+
+	accept    chan NetworkConnection
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *LoopbackNetworkListener) Accept(ctx context.Context) (NetworkConnection, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.close:
+		return nil, ErrTransportClosed
+	case nc := <-l.accept:
+		return nc, nil
+	}
+}
+
+func (l *LoopbackNetworkListener) Addr() net.Addr {
+	return loopbackAddr(l.port)
+}
+
+func (l *LoopbackNetworkListener) Close() error {
+	l.closeOnce.Do(func() {
+		loopbackMu.Lock()
+		delete(loopbackListeners, l.port)
+		loopbackMu.Unlock()
+
+		close(l.close)
+	})
+	return nil
+}
+
+// loopbackEndpoint is one side of a loopback connection.
+type loopbackEndpoint struct {
+	peer *loopbackEndpoint
+
+	// network carries the network protocol messages sent by the peer.
+	network   *loopbackPipe
+	accept    chan *LoopbackApplicationStream
+	datagrams chan []byte
+
+	mu           sync.Mutex
+	streams      map[int64]*LoopbackApplicationStream // nil once closed
+	nextStreamID int64
+
+	// close is shared by both endpoints.
+	close     chan struct{}
+	closeOnce *sync.Once
+}
+
+// newLoopbackEndpoints returns the client and server endpoint of a
+// connection. Stream IDs are allocated like client and server initiated
+// bidirectional QUIC streams.
+func newLoopbackEndpoints() (*loopbackEndpoint, *loopbackEndpoint) {
+	closeCh := make(chan struct{})
+	closeOnce := &sync.Once{}
+
+	a := &loopbackEndpoint{
+		network:      newLoopbackPipe(),
+		accept:       make(chan *LoopbackApplicationStream, loopbackAcceptBacklog),
+		datagrams:    make(chan []byte, loopbackDatagramBacklog),
+		streams:      make(map[int64]*LoopbackApplicationStream),
+		nextStreamID: 0,
+		close:        closeCh,
+		closeOnce:    closeOnce,
+	}
+	b := &loopbackEndpoint{
+		network:      newLoopbackPipe(),
+		accept:       make(chan *LoopbackApplicationStream, loopbackAcceptBacklog),
+		datagrams:    make(chan []byte, loopbackDatagramBacklog),
+		streams:      make(map[int64]*LoopbackApplicationStream),
+		nextStreamID: 1,
+		close:        closeCh,
+		closeOnce:    closeOnce,
+	}
+	a.peer = b
+	b.peer = a
+
+	return a, b
+}
+
+func (e *loopbackEndpoint) openStream(ctx context.Context) (*LoopbackApplicationStream, error) {
+	e.mu.Lock()
+	id := e.nextStreamID
+	e.nextStreamID += 4
+	e.mu.Unlock()
+
+	aToB := newLoopbackPipe()
+	bToA := newLoopbackPipe()
+	local := &LoopbackApplicationStream{id: id, endpoint: e, in: bToA, out: aToB}
+	remote := &LoopbackApplicationStream{id: id, endpoint: e.peer, in: aToB, out: bToA}
+
+	if !e.track(local) || !e.peer.track(remote) {
+		return nil, ErrTransportClosed
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.close:
+		return nil, ErrTransportClosed
+	case e.peer.accept <- remote:
+		return local, nil
+	}
+}
+
+func (e *loopbackEndpoint) acceptStream(ctx context.Context) (*LoopbackApplicationStream, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-e.close:
+		return nil, ErrTransportClosed
+	case s := <-e.accept:
+		return s, nil
+	}
+}
+
+// track adds a stream to the endpoint. If the endpoint is closed already,
+// the stream is reset and false is returned.
+func (e *loopbackEndpoint) track(s *LoopbackApplicationStream) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.streams == nil {
+		s.in.reset(ErrTransportClosed)
+		s.out.reset(ErrTransportClosed)
+		return false
+	}
+	e.streams[s.id] = s
+	return true
+}
+
+func (e *loopbackEndpoint) untrack(s *LoopbackApplicationStream) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.streams, s.id)
+}
+
+// shutdown closes both endpoints of the connection.
+func (e *loopbackEndpoint) shutdown() {
+	e.closeOnce.Do(func() {
+		close(e.close)
+		for _, ep := range []*loopbackEndpoint{e, e.peer} {
+			ep.network.closeWithError(ErrTransportClosed)
+
+			ep.mu.Lock()
+			for _, s := range ep.streams {
+				s.in.closeWithError(ErrTransportClosed)
+				s.out.closeWithError(ErrTransportClosed)
+			}
+			ep.streams = nil
+			ep.mu.Unlock()
+		}
+	})
+}
+
+var _ NetworkConnection = &LoopbackNetworkConnection{}
+
+type LoopbackNetworkConnection struct {
+	endpoint *loopbackEndpoint
+	state    tls.ConnectionState
+}
+
+func newLoopbackNetworkConnection(e *loopbackEndpoint, state tls.ConnectionState) *LoopbackNetworkConnection {
+	return &LoopbackNetworkConnection{
+		endpoint: e,
+		state:    state,
+	}
+}
+
+func (c *LoopbackNetworkConnection) Read(p []byte) (int, error) {
+	return c.endpoint.network.Read(p)
+}
+
+func (c *LoopbackNetworkConnection) Write(p []byte) (int, error) {
+	return c.endpoint.peer.network.Write(p)
+}
+
+func (c *LoopbackNetworkConnection) IsReliable() bool {
+	return true
+}
+
+func (c *LoopbackNetworkConnection) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+func (c *LoopbackNetworkConnection) IntoApplicationConnection() (ApplicationConnection, error) {
+	c.endpoint.network.closeWithError(ErrTransportHandedOff)
+	return &LoopbackApplicationConnection{endpoint: c.endpoint}, nil
+}
+
+func (c *LoopbackNetworkConnection) Close() error {
+	c.endpoint.shutdown()
+	return nil
+}
+
+var _ ApplicationConnection = &LoopbackApplicationConnection{}
+var _ DatagramConnection = &LoopbackApplicationConnection{}
+
+type LoopbackApplicationConnection struct {
+	endpoint *loopbackEndpoint
+}
+
+func (c *LoopbackApplicationConnection) AcceptStream(ctx context.Context) (ApplicationStream, error) {
+	return c.endpoint.acceptStream(ctx)
+}
+
+func (c *LoopbackApplicationConnection) OpenStreamSync(ctx context.Context) (ApplicationStream, error) {
+	return c.endpoint.openStream(ctx)
+}
+
+// SendDatagram queues a datagram for the peer. Like on a network, the
+// datagram is dropped if the peer doesn't keep up.
+func (c *LoopbackApplicationConnection) SendDatagram(p []byte) error {
+	if len(p) > quicMaxDatagramSize {
+		return ErrDatagramTooLarge
+	}
+
+	select {
+	case <-c.endpoint.close:
+		return ErrTransportClosed
+	default:
+	}
+
+	select {
+	case c.endpoint.peer.datagrams <- append([]byte(nil), p...):
+	default:
+	}
+	return nil
+}
+
+func (c *LoopbackApplicationConnection) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.endpoint.close:
+		return nil, ErrTransportClosed
+	case p := <-c.endpoint.datagrams:
+		return p, nil
+	}
+}
+
+// MaxDatagramSize matches the QUIC transport.
+func (c *LoopbackApplicationConnection) MaxDatagramSize() int {
+	return quicMaxDatagramSize
+}
+
+func (c *LoopbackApplicationConnection) Close() error {
+	c.endpoint.shutdown()
+	return nil
+}
+
+var _ ApplicationStream = &LoopbackApplicationStream{}
+
+type LoopbackApplicationStream struct {
+	id       int64
+	endpoint *loopbackEndpoint
+	in       *loopbackPipe
+	out      *loopbackPipe
+
+	mu        sync.Mutex
+	readDone  bool
+	writeDone bool
+}
+
+func (s *LoopbackApplicationStream) StreamID() int64 {
+	return s.id
+}
+
+func (s *LoopbackApplicationStream) Read(p []byte) (int, error) {
+	n, err := s.in.Read(p)
+	if errors.Is(err, ErrStreamReset) {
+		// A reset aborts both directions.
+		s.finish(true, true)
+	} else if err != nil {
+		s.finish(true, false)
+	}
+	return n, err
+}
+
+func (s *LoopbackApplicationStream) Write(p []byte) (int, error) {
+	n, err := s.out.Write(p)
+	if err != nil {
+		s.finish(false, true)
+	}
+	return n, err
+}
+
+// Close closes the write direction of the stream.
+func (s *LoopbackApplicationStream) Close() error {
+	s.out.closeWithError(io.EOF)
+	s.finish(false, true)
+	return nil
+}
+
+func (s *LoopbackApplicationStream) Reset() error {
+	s.in.reset(ErrStreamReset)
+	s.out.reset(ErrStreamReset)
+	s.finish(true, true)
+	return nil
+}
+
+// finish marks directions of the stream as done. The stream is untracked
+// once both directions are done.
+func (s *LoopbackApplicationStream) finish(read, write bool) {
+	s.mu.Lock()
+	s.readDone = s.readDone || read
+	s.writeDone = s.writeDone || write
+	done := s.readDone && s.writeDone
+	s.mu.Unlock()
+
+	if done {
+		s.endpoint.untrack(s)
+	}
+}
+
+// loopbackPipe is an unbounded in-memory pipe. Writes never block.
+type loopbackPipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	err  error
+}
+
+func newLoopbackPipe() *loopbackPipe {
+	p := &loopbackPipe{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *loopbackPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if p.buf.Len() > 0 {
+		return p.buf.Read(b)
+	}
+	return 0, p.err
+}
+
+func (p *loopbackPipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if errors.Is(p.err, io.EOF) {
+		return 0, io.ErrClosedPipe
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	p.buf.Write(b)
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+// closeWithError closes the pipe. Reads return err once the buffered
+// data is consumed. Only the first error is kept.
+func (p *loopbackPipe) closeWithError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil {
+		p.err = err
+	}
+	p.cond.Broadcast()
+}
+
+// reset closes the pipe and discards the buffered data. A graceful close
+// is overridden by the reset.
+func (p *loopbackPipe) reset(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err == nil || errors.Is(p.err, io.EOF) {
+		p.err = err
+	}
+	p.buf.Reset()
+	p.cond.Broadcast()
+}
@@ -0,0 +1,330 @@
+package ospc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLoopbackTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider := NewMemoryDiscovery()
+
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportLoopback, nil)
+	l.WithDiscoveryProvider(provider)
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := NewDiscoverer()
+	d.WithDiscoveryProvider(provider)
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	ra, err := d.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First connection: info exchange and PSK authentication.
+	uConn, err := ra.Dial(ctx, AgentTransportLoopback, dialAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uConn.RemoteAgent().Info().DisplayName != "Listener" {
+		t.Fatalf("unexpected remote agent: %s", uConn.RemoteAgent().Info().DisplayName)
+	}
+	lConn, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dConn, aConn := authenticatePSK(ctx, t, uConn, lConn)
+	defer dConn.Close()
+	defer aConn.Close()
+
+	// Data channel
+	dc, err := dConn.OpenDataChannel(ctx, DataChannelParameters{Label: "chat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dc.SendMessage([]byte("Hello!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adc, err := aConn.AcceptDataChannel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := adc.ReceiveMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "Hello!" {
+		t.Fatalf("unexpected message: %s", msg)
+	}
+
+	// Pooled WebTransport
+	wt, err := dConn.NewTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awt, err := aConn.AcceptTransport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := wt.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, err := awt.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(as, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected stream data: %s", buf)
+	}
+	if s.StreamID() != as.StreamID() {
+		t.Fatalf("stream ID mismatch: %d != %d", s.StreamID(), as.StreamID())
+	}
+
+	// Second connection: mutually trusted peers skip authentication.
+	uConn, err = ra.Dial(ctx, AgentTransportLoopback, dialAgent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lConn, err = l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, ok := uConn.Authenticated()
+	if !ok {
+		t.Fatalf("dialer not authenticated as trusted peer")
+	}
+	defer conn.Close()
+	conn, ok = lConn.Authenticated()
+	if !ok {
+		t.Fatalf("listener not authenticated as trusted peer")
+	}
+	defer conn.Close()
+}
+
+func TestLoopbackStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := newLoopbackEndpoints()
+	defer client.shutdown()
+	cConn := &LoopbackApplicationConnection{endpoint: client}
+	sConn := &LoopbackApplicationConnection{endpoint: server}
+
+	tracked := func(e *loopbackEndpoint) int {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return len(e.streams)
+	}
+
+	cs, err := cConn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := sConn.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.StreamID() != 0 || ss.StreamID() != 0 {
+		t.Fatalf("unexpected stream IDs: %d, %d", cs.StreamID(), ss.StreamID())
+	}
+	ss2, err := sConn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs2, err := cConn.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss2.StreamID() != 1 || cs2.StreamID() != 1 {
+		t.Fatalf("unexpected stream IDs: %d, %d", ss2.StreamID(), cs2.StreamID())
+	}
+
+	// Half-closed streams stay tracked.
+	_, err = cs.Write([]byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = cs.Close()
+	data, err := io.ReadAll(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ping" {
+		t.Fatalf("unexpected stream data: %s", data)
+	}
+	if tracked(client) != 2 || tracked(server) != 2 {
+		t.Fatalf("half-closed stream untracked: %d, %d", tracked(client), tracked(server))
+	}
+
+	// Streams are untracked once both directions are done.
+	_ = ss.Close()
+	_, err = io.ReadAll(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked(client) != 1 || tracked(server) != 1 {
+		t.Fatalf("closed stream still tracked: %d, %d", tracked(client), tracked(server))
+	}
+
+	_ = ss2.Reset()
+	_, err = cs2.Read(make([]byte, 1))
+	if !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("expected reset, got %v", err)
+	}
+	if tracked(client) != 0 || tracked(server) != 0 {
+		t.Fatalf("reset stream still tracked: %d, %d", tracked(client), tracked(server))
+	}
+}
+
+// authenticatePSK authenticates the dialing and listening side of a
+// connection with a fixed PSK.
+func authenticatePSK(ctx context.Context, t *testing.T, dialer, listener *UnauthenticatedConnection) (*Connection, *Connection) {
+	t.Helper()
+
+	psk := []byte("0124")
+	type result struct {
+		conn *Connection
+		err  error
+	}
+	lResult := make(chan result, 1)
+	go func() {
+		_, err := listener.AcceptAuthenticate(ctx)
+		if err != nil {
+			lResult <- result{err: err}
+			return
+		}
+		conn, err := listener.AuthenticatePSK(ctx, psk)
+		lResult <- result{conn: conn, err: err}
+	}()
+
+	dConn, err := dialer.AuthenticatePSK(ctx, psk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-lResult
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+
+	return dConn, res.conn
+}
+
+// newLoopbackConnections returns an authenticated pair of connections
+// over the loopback transport.
+func newLoopbackConnections(ctx context.Context, t *testing.T) (*Connection, *Connection) {
+	t.Helper()
+
+	return newLoopbackConnectionsWithConfig(ctx, t, NewAgentConfig("Listener"))
+}
+
+// newLoopbackConnectionsWithConfig is newLoopbackConnections with the
+// config of the listening agent.
+func newLoopbackConnectionsWithConfig(ctx context.Context, t *testing.T, listenConfig AgentConfig) (*Connection, *Connection) {
+	t.Helper()
+
+	listenAgent, err := NewAgent(listenConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportLoopback, nil)
+	l.WithDiscoveryProvider(NewMemoryDiscovery())
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uConn, err := DialAddr(ctx, l.Addr().String(), string(listenAgent.PeerID), AgentTransportLoopback, dialAgent, WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lConn, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dConn, aConn := authenticatePSK(ctx, t, uConn, lConn)
+	t.Cleanup(func() {
+		_ = dConn.Close()
+		_ = aConn.Close()
+	})
+
+	return dConn, aConn
+}
+
+func TestLoopbackApplicationALPN(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listenAgent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(listenAgent, AgentTransportLoopback, nil)
+	l.WithDiscoveryProvider(NewMemoryDiscovery())
+	_ = l.ListenApplication("app", nil)
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	dialAgent, err := NewAgent(NewAgentConfig("Dialer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Applications are only offered over QUIC.
+	tlsConfig := newDialTLSConfig(dialAgent, string(listenAgent.PeerID), nil, "")
+	tlsConfig.NextProtos = []string{"app"}
+	_, err = NewLoopbackTransport().DialAddr(ctx, l.Addr().String(), tlsConfig)
+	if err == nil {
+		t.Fatal("expected ALPN mismatch")
+	}
+
+	// OSP connections are still accepted.
+	uConn, err := DialAddr(ctx, l.Addr().String(), string(listenAgent.PeerID), AgentTransportLoopback, dialAgent, WithAuthInitiationToken(listenAgent.AuthInitiationToken()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uConn.Close()
+}
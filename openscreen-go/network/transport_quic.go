@@ -6,12 +6,25 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/quic-go/quic-go"
 )
 
 var _ NetworkTransport = &QuicTransport{}
 
+// quicMaxDatagramSize is the largest datagram payload. quic-go limits
+// DATAGRAM frames to 1200 bytes, including the frame type and length.
+const quicMaxDatagramSize = 1200 - 1 - 2
+
+// newQuicConfig returns the config for all QUIC connections.
+func newQuicConfig() *quic.Config {
+	return &quic.Config{
+		EnableDatagrams: true,
+		Tracer:          newQuicStatsTracer,
+	}
+}
+
 type QuicTransport struct{}
 
 func NewQuicTransport() *QuicTransport {
@@ -19,7 +32,7 @@ func NewQuicTransport() *QuicTransport {
 }
 
 func (t *QuicTransport) DialAddr(ctx context.Context, addr string, tlsConf *tls.Config) (NetworkConnection, error) {
-	qConn, err := quic.DialAddr(ctx, addr, tlsConf, nil)
+	qConn, err := quic.DialAddr(ctx, addr, tlsConf, newQuicConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -37,54 +50,168 @@ func (g *ospConnectionIDGenerator) ConnectionIDLen() int {
 	return 0
 }
 
+// quicHandshakeTimeout bounds how long a remote address may take to
+// complete its handshake before its transport is discarded.
+const quicHandshakeTimeout = 10 * time.Second
+
 func (t *QuicTransport) ListenAddr(addr string, tlsConf *tls.Config) (NetworkListener, error) {
 	// ListenAddr is a version of quic.ListenAddr that overwrites the
 	// ConnectionID behavior to match the OSP zero-length requirement.
+	// Since zero-length connection IDs can't tell connections apart, the
+	// socket is demultiplexed by remote address and each remote address
+	// is served by its own transport.
 	conn, err := listenUDP(addr)
 	if err != nil {
 		return nil, err
 	}
-	qConn, err := (&quic.Transport{
-		Conn:                  conn,
-		ConnectionIDGenerator: &ospConnectionIDGenerator{},
-	}).Listen(tlsConf, nil)
-	if err != nil {
-		return nil, err
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &QuicNetworkListener{
+		mux:     newUDPMux(conn),
+		tlsConf: tlsConf,
+		conns:   make(chan quic.Connection),
+		ctx:     ctx,
+		cancel:  cancel,
 	}
+	go l.run()
 
-	return &QuicNetworkListener{
-		listener: qConn,
-	}, nil
+	return l, nil
 }
 
 var _ NetworkListener = &QuicNetworkListener{}
 
 type QuicNetworkListener struct {
-	listener *quic.Listener
+	mux     *udpMux
+	tlsConf *tls.Config
+	conns   chan quic.Connection
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex // Protects active and closed
+	active int
+	closed bool
 }
 
-func (l *QuicNetworkListener) Accept(ctx context.Context) (NetworkConnection, error) {
-	conn, err := l.listener.Accept(ctx)
+func (l *QuicNetworkListener) run() {
+	for {
+		mc, err := l.mux.Accept()
+		if err != nil {
+			return
+		}
+		if !l.addActive() {
+			_ = mc.Close()
+			continue
+		}
+		go l.serve(mc)
+	}
+}
+
+// serve runs the transport for a single remote address until its
+// connection is closed.
+func (l *QuicNetworkListener) serve(mc *udpMuxConn) {
+	defer l.removeActive()
+	defer mc.Close()
+
+	tr := &quic.Transport{
+		Conn:                  mc,
+		ConnectionIDGenerator: &ospConnectionIDGenerator{},
+	}
+	defer tr.Close()
+
+	ln, err := tr.Listen(l.tlsConf, newQuicConfig())
 	if err != nil {
-		return nil, err
+		return
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(l.ctx, quicHandshakeTimeout)
+	conn, err := ln.Accept(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	l.mux.established(mc)
+
+	select {
+	case l.conns <- conn:
+	case <-l.ctx.Done():
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+
+	<-conn.Context().Done()
+}
+
+func (l *QuicNetworkListener) addActive() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return false
+	}
+	l.active++
+	return true
+}
+
+// removeActive closes the socket once the listener is closed and the
+// last connection is done.
+func (l *QuicNetworkListener) removeActive() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if l.closed && l.active == 0 {
+		_ = l.mux.Close()
+	}
+}
+
+func (l *QuicNetworkListener) Accept(ctx context.Context) (NetworkConnection, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.ctx.Done():
+		return nil, quic.ErrServerClosed
+	case conn := <-l.conns:
+		return NewQuicNetworkConnection(conn), nil
 	}
-	return NewQuicNetworkConnection(conn), nil
 }
 
 func (l *QuicNetworkListener) Addr() net.Addr {
-	return l.listener.Addr()
+	return l.mux.LocalAddr()
+}
+
+// Close stops accepting connections. Accepted connections stay open.
+func (l *QuicNetworkListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	l.cancel()
+	if l.active == 0 {
+		return l.mux.Close()
+	}
+	return nil
 }
 
 var _ NetworkConnection = &QuicNetworkConnection{}
 
 type QuicNetworkConnection struct {
-	conn quic.Connection
+	conn  quic.Connection
+	stats *quicStatsTracer
 
 	// Read: pipe fed sequentially by run()
 	pr *io.PipeReader
 	pw *io.PipeWriter
 
 	// Lifecycle
+	// run() is started on the first Read so connections handed to an
+	// ALPNListener keep all their streams.
+	startOnce    sync.Once
+	runCtx       context.Context
 	acceptCancel context.CancelFunc
 	doneCh       chan struct{} // closed when run() exits
 
@@ -97,15 +224,22 @@ func NewQuicNetworkConnection(conn quic.Connection) *QuicNetworkConnection {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	q := &QuicNetworkConnection{
 		conn:         conn,
+		stats:        quicConnectionStats(conn),
 		pr:           pr,
 		pw:           pw,
+		runCtx:       ctx,
 		acceptCancel: cancelFunc,
 		doneCh:       make(chan struct{}),
 	}
-	go q.run(ctx)
 	return q
 }
 
+func (q *QuicNetworkConnection) start() {
+	q.startOnce.Do(func() {
+		go q.run(q.runCtx)
+	})
+}
+
 func (q *QuicNetworkConnection) run(ctx context.Context) {
 	defer close(q.doneCh)
 	defer q.pw.CloseWithError(q.closeError())
@@ -146,6 +280,7 @@ func (q *QuicNetworkConnection) run(ctx context.Context) {
 }
 
 func (q *QuicNetworkConnection) Read(p []byte) (int, error) {
+	q.start()
 	return q.pr.Read(p)
 }
 
@@ -194,12 +329,16 @@ func (q *QuicNetworkConnection) shutdown(err error) {
 	q.setCloseError(err)
 	q.acceptCancel()
 	q.pw.CloseWithError(err)
+	q.startOnce.Do(func() {
+		// Never started
+		close(q.doneCh)
+	})
 	<-q.doneCh
 }
 
 func (q *QuicNetworkConnection) IntoApplicationConnection() (ApplicationConnection, error) {
 	q.shutdown(ErrTransportHandedOff)
-	return &QuicApplicationConnection{conn: q.conn}, nil
+	return &QuicApplicationConnection{conn: q.conn, stats: q.stats}, nil
 }
 
 func (q *QuicNetworkConnection) Close() error {
@@ -209,9 +348,12 @@ func (q *QuicNetworkConnection) Close() error {
 }
 
 var _ ApplicationConnection = &QuicApplicationConnection{}
+var _ DatagramConnection = &QuicApplicationConnection{}
+var _ StatsConnection = &QuicApplicationConnection{}
 
 type QuicApplicationConnection struct {
-	conn quic.Connection
+	conn  quic.Connection
+	stats *quicStatsTracer
 }
 
 func (q *QuicApplicationConnection) AcceptStream(ctx context.Context) (ApplicationStream, error) {
@@ -230,6 +372,33 @@ func (q *QuicApplicationConnection) OpenStreamSync(ctx context.Context) (Applica
 	return &QuicApplicationStream{stream: s}, nil
 }
 
+func (q *QuicApplicationConnection) SendDatagram(p []byte) error {
+	return sendQuicDatagram(q.conn, p)
+}
+
+func (q *QuicApplicationConnection) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return q.conn.ReceiveMessage(ctx)
+}
+
+func (q *QuicApplicationConnection) MaxDatagramSize() int {
+	return quicMaxDatagramSize
+}
+
+func (q *QuicApplicationConnection) Stats() (ConnectionStats, error) {
+	return q.stats.Stats()
+}
+
+// sendQuicDatagram sends p in a DATAGRAM frame.
+func sendQuicDatagram(conn quic.Connection, p []byte) error {
+	if !conn.ConnectionState().SupportsDatagrams {
+		return ErrDatagramsUnsupported
+	}
+	if len(p) > quicMaxDatagramSize {
+		return ErrDatagramTooLarge
+	}
+	return conn.SendMessage(p)
+}
+
 func (q *QuicApplicationConnection) Close() error {
 	// TODO: refine error?
 	return q.conn.CloseWithError(1, "Closed")
@@ -241,6 +410,10 @@ type QuicApplicationStream struct {
 	stream quic.Stream
 }
 
+func (s *QuicApplicationStream) StreamID() int64 {
+	return int64(s.stream.StreamID())
+}
+
 func (s *QuicApplicationStream) Read(p []byte) (int, error) {
 	return s.stream.Read(p)
 }
@@ -252,3 +425,67 @@ func (s *QuicApplicationStream) Write(p []byte) (int, error) {
 func (s *QuicApplicationStream) Close() error {
 	return s.stream.Close()
 }
+
+func (s *QuicApplicationStream) Reset() error {
+	s.stream.CancelWrite(0)
+	s.stream.CancelRead(0)
+	return nil
+}
+
+var _ ApplicationStream = &QuicApplicationSendStream{}
+
+// QuicApplicationSendStream is the sending end of a unidirectional stream.
+type QuicApplicationSendStream struct {
+	stream quic.SendStream
+}
+
+func (s *QuicApplicationSendStream) StreamID() int64 {
+	return int64(s.stream.StreamID())
+}
+
+func (s *QuicApplicationSendStream) Read(p []byte) (int, error) {
+	return 0, ErrSendOnlyStream
+}
+
+func (s *QuicApplicationSendStream) Write(p []byte) (int, error) {
+	return s.stream.Write(p)
+}
+
+func (s *QuicApplicationSendStream) Close() error {
+	return s.stream.Close()
+}
+
+func (s *QuicApplicationSendStream) Reset() error {
+	s.stream.CancelWrite(0)
+	return nil
+}
+
+var _ ApplicationStream = &QuicApplicationReceiveStream{}
+
+// QuicApplicationReceiveStream is the receiving end of a unidirectional
+// stream.
+type QuicApplicationReceiveStream struct {
+	stream quic.ReceiveStream
+}
+
+func (s *QuicApplicationReceiveStream) StreamID() int64 {
+	return int64(s.stream.StreamID())
+}
+
+func (s *QuicApplicationReceiveStream) Read(p []byte) (int, error) {
+	return s.stream.Read(p)
+}
+
+func (s *QuicApplicationReceiveStream) Write(p []byte) (int, error) {
+	return 0, ErrReceiveOnlyStream
+}
+
+// Close is a no-op, there is no write direction.
+func (s *QuicApplicationReceiveStream) Close() error {
+	return nil
+}
+
+func (s *QuicApplicationReceiveStream) Reset() error {
+	s.stream.CancelRead(0)
+	return nil
+}
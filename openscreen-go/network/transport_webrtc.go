@@ -19,19 +19,36 @@ const MTU = 8192
 
 var _ NetworkTransport = &DTLSTransport{}
 
-type DTLSTransport struct{}
+type DTLSTransport struct {
+	loggerFactory logging.LoggerFactory
+}
+
+func NewDTLSTransport(loggerFactory logging.LoggerFactory) *DTLSTransport {
+	return &DTLSTransport{
+		loggerFactory: loggerFactory,
+	}
+}
 
-func NewDTLSTransport() *DTLSTransport {
-	return &DTLSTransport{}
+// getLoggerFactory returns the logger factory, a zero DTLSTransport
+// uses the default one.
+func (t *DTLSTransport) getLoggerFactory() logging.LoggerFactory {
+	return loggerFactoryOrDefault(t.loggerFactory)
 }
 
-func toConnectionState(dtlsState *dtls.State) tls.ConnectionState {
+func loggerFactoryOrDefault(loggerFactory logging.LoggerFactory) logging.LoggerFactory {
+	if loggerFactory == nil {
+		return logging.NewDefaultLoggerFactory()
+	}
+	return loggerFactory
+}
+
+func toConnectionState(dtlsState *dtls.State, log logging.LeveledLogger) tls.ConnectionState {
 	peerCertificates := []*x509.Certificate{}
 
 	for i, raw := range dtlsState.PeerCertificates {
 		leaf, err := x509.ParseCertificate(raw)
 		if err != nil {
-			fmt.Printf("warning: failed to parse peer certificate %d: %v", i, err)
+			log.Warnf("failed to parse peer certificate %d: %v", i, err)
 			continue
 		}
 		peerCertificates = append(peerCertificates, leaf)
@@ -63,17 +80,19 @@ func toClientAuthType(typ tls.ClientAuthType) dtls.ClientAuthType {
 	}
 }
 
-func toDtlsConfig(tlsConf *tls.Config) *dtls.Config {
+func toDtlsConfig(tlsConf *tls.Config, loggerFactory logging.LoggerFactory) *dtls.Config {
+	log := loggerFactory.NewLogger(logScopeTransport)
 	dtlsConfig := &dtls.Config{
 		InsecureSkipVerify: tlsConf.InsecureSkipVerify,
 		VerifyConnection: func(s *dtls.State) error {
-			return tlsConf.VerifyConnection(toConnectionState(s))
+			return tlsConf.VerifyConnection(toConnectionState(s, log))
 		},
 		SupportedProtocols:    tlsConf.NextProtos,
 		ServerName:            tlsConf.ServerName,
 		Certificates:          tlsConf.Certificates,
 		ClientAuth:            toClientAuthType(tlsConf.ClientAuth),
 		VerifyPeerCertificate: tlsConf.VerifyPeerCertificate,
+		LoggerFactory:         loggerFactory,
 	}
 
 	return dtlsConfig
@@ -85,7 +104,7 @@ func (t *DTLSTransport) DialAddr(ctx context.Context, addr string, tlsConf *tls.
 		return nil, err
 	}
 
-	dtlsConfig := toDtlsConfig(tlsConf)
+	dtlsConfig := toDtlsConfig(tlsConf, t.getLoggerFactory())
 	dtlsConn, err := dtls.Dial("udp", udpAddr, dtlsConfig)
 	if err != nil {
 		return nil, err
@@ -95,7 +114,7 @@ func (t *DTLSTransport) DialAddr(ctx context.Context, addr string, tlsConf *tls.
 		return nil, fmt.Errorf("dial failed to handshake: %v", err)
 	}
 
-	return NewDTLSNetworkConnection(dtlsConn), nil
+	return NewDTLSNetworkConnection(dtlsConn, t.getLoggerFactory()), nil
 }
 
 func (t *DTLSTransport) ListenAddr(addr string, tlsConf *tls.Config) (NetworkListener, error) {
@@ -104,21 +123,23 @@ func (t *DTLSTransport) ListenAddr(addr string, tlsConf *tls.Config) (NetworkLis
 		return nil, err
 	}
 
-	dtlsConfig := toDtlsConfig(tlsConf)
+	dtlsConfig := toDtlsConfig(tlsConf, t.getLoggerFactory())
 	inner, err := dtls.Listen("udp", udpAddr, dtlsConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	return &DTLSNetworkListener{
-		listener: inner,
+		listener:      inner,
+		loggerFactory: t.getLoggerFactory(),
 	}, nil
 }
 
 var _ NetworkListener = &DTLSNetworkListener{}
 
 type DTLSNetworkListener struct {
-	listener net.Listener
+	listener      net.Listener
+	loggerFactory logging.LoggerFactory
 }
 
 func (l *DTLSNetworkListener) Accept(ctx context.Context) (NetworkConnection, error) {
@@ -131,13 +152,17 @@ func (l *DTLSNetworkListener) Accept(ctx context.Context) (NetworkConnection, er
 	if err := dtlsConn.HandshakeContext(ctx); err != nil {
 		return nil, fmt.Errorf("accept failed to handshake: %v", err)
 	}
-	return NewDTLSNetworkConnection(dtlsConn), nil
+	return NewDTLSNetworkConnection(dtlsConn, l.loggerFactory), nil
 }
 
 func (l *DTLSNetworkListener) Addr() net.Addr {
 	return l.listener.Addr()
 }
 
+func (l *DTLSNetworkListener) Close() error {
+	return l.listener.Close()
+}
+
 // DTLS connection wrapper that can be handed off between NetworkConnection and
 // ApplicationConnection.
 type dtlsConnectionBase struct {
@@ -290,14 +315,20 @@ var _ NetworkConnection = &QuicNetworkConnection{}
 type DTLSNetworkConnection struct {
 	base *dtlsConnectionBase
 
+	loggerFactory logging.LoggerFactory
+	log           logging.LeveledLogger
+
 	mu       sync.Mutex // Protects state
 	closeErr error
 }
 
-func NewDTLSNetworkConnection(conn *dtls.Conn) *DTLSNetworkConnection {
+func NewDTLSNetworkConnection(conn *dtls.Conn, loggerFactory logging.LoggerFactory) *DTLSNetworkConnection {
+	loggerFactory = loggerFactoryOrDefault(loggerFactory)
 	base := newDtlsConnectionBase(conn)
 	return &DTLSNetworkConnection{
-		base: base,
+		base:          base,
+		loggerFactory: loggerFactory,
+		log:           loggerFactory.NewLogger(logScopeTransport),
 	}
 }
 
@@ -324,11 +355,11 @@ func (c *DTLSNetworkConnection) IsReliable() bool {
 func (c *DTLSNetworkConnection) ConnectionState() tls.ConnectionState {
 	dtlsState, ok := c.base.conn.ConnectionState()
 	if !ok {
-		fmt.Println("warning: ConnectionState called before ConnectionState was set")
+		c.log.Warn("ConnectionState called before ConnectionState was set")
 		return tls.ConnectionState{}
 	}
 
-	return toConnectionState(&dtlsState)
+	return toConnectionState(&dtlsState, c.log)
 }
 
 func (c *DTLSNetworkConnection) closeError() error {
@@ -361,7 +392,7 @@ func (c *DTLSNetworkConnection) IntoApplicationConnection() (ApplicationConnecti
 	sctpAssociation, err := sctp.Client(sctp.Config{
 		NetConn:            inner,
 		EnableZeroChecksum: false,
-		LoggerFactory:      logging.NewDefaultLoggerFactory(),
+		LoggerFactory:      c.loggerFactory,
 	})
 	if err != nil {
 		return nil, err
@@ -431,6 +462,10 @@ func NewSCTPApplicationStream(stream *sctp.Stream) *SCTPApplicationStream {
 	}
 }
 
+func (s *SCTPApplicationStream) StreamID() int64 {
+	return int64(s.stream.StreamIdentifier())
+}
+
 func (s *SCTPApplicationStream) Read(p []byte) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -463,6 +498,12 @@ func (s *SCTPApplicationStream) Close() error {
 	return s.stream.Close()
 }
 
+// Reset resets the stream. SCTP has no half-closed streams, this is the
+// same as Close.
+func (s *SCTPApplicationStream) Reset() error {
+	return s.stream.Close()
+}
+
 func writeChunked(dst io.Writer, p []byte) (int, error) {
 	b := p
 	nr := 0
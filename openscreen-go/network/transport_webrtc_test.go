@@ -0,0 +1,25 @@
+package ospc
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestDTLSTransportZeroValue(t *testing.T) {
+	agent, err := NewAgent(NewAgentConfig("Listener"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero DTLSTransport uses the default logger factory.
+	transport := &DTLSTransport{}
+	l, err := transport.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{*agent.Certificate},
+		NextProtos:   []string{ALPN_OSP},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Close()
+}
@@ -0,0 +1,170 @@
+package ospc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TrustedPeer is a remote agent that successfully completed PSK
+// authentication with the local agent.
+type TrustedPeer struct {
+	PeerID      PeerID    `json:"peer_id"`
+	DisplayName string    `json:"display_name"`
+	ModelName   string    `json:"model_name"`
+	TrustedAt   time.Time `json:"trusted_at"`
+}
+
+// TrustStore records the fingerprints of trusted peers. Connections to
+// mutually trusted peers skip the PSK authentication.
+type TrustStore interface {
+	// Get returns the trusted peer with the given PeerID.
+	Get(id PeerID) (TrustedPeer, bool)
+	// Add adds or replaces a trusted peer.
+	Add(peer TrustedPeer) error
+	// Remove revokes the trust in a peer.
+	Remove(id PeerID) error
+	// List returns all trusted peers.
+	List() []TrustedPeer
+}
+
+var _ TrustStore = (*MemoryTrustStore)(nil)
+
+// MemoryTrustStore is a TrustStore that is kept in memory only.
+type MemoryTrustStore struct {
+	mu    sync.Mutex
+	peers map[PeerID]TrustedPeer
+}
+
+// NewMemoryTrustStore creates an empty MemoryTrustStore.
+func NewMemoryTrustStore() *MemoryTrustStore {
+	return &MemoryTrustStore{
+		peers: make(map[PeerID]TrustedPeer),
+	}
+}
+
+func (s *MemoryTrustStore) Get(id PeerID) (TrustedPeer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, ok := s.peers[id]
+	return peer, ok
+}
+
+func (s *MemoryTrustStore) Add(peer TrustedPeer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.peers[peer.PeerID] = peer
+	return nil
+}
+
+func (s *MemoryTrustStore) Remove(id PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.peers, id)
+	return nil
+}
+
+func (s *MemoryTrustStore) List() []TrustedPeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]TrustedPeer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PeerID < peers[j].PeerID
+	})
+	return peers
+}
+
+var _ TrustStore = (*FileTrustStore)(nil)
+
+// FileTrustStore is a TrustStore that is persisted to a JSON file.
+// Every change is written to disk immediately.
+type FileTrustStore struct {
+	path string
+
+	mu    sync.Mutex
+	inner *MemoryTrustStore
+}
+
+// NewFileTrustStore opens the trust store at path. The file is created on
+// the first change if it doesn't exist yet.
+func NewFileTrustStore(path string) (*FileTrustStore, error) {
+	s := &FileTrustStore{
+		path:  path,
+		inner: NewMemoryTrustStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []TrustedPeer
+	err = json.Unmarshal(data, &peers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trust store %s: %w", path, err)
+	}
+	for _, peer := range peers {
+		s.inner.peers[peer.PeerID] = peer
+	}
+
+	return s, nil
+}
+
+func (s *FileTrustStore) Get(id PeerID) (TrustedPeer, bool) {
+	return s.inner.Get(id)
+}
+
+func (s *FileTrustStore) Add(peer TrustedPeer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.inner.Add(peer)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileTrustStore) Remove(id PeerID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.inner.Remove(id)
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileTrustStore) List() []TrustedPeer {
+	return s.inner.List()
+}
+
+// Caller should hold the lock.
+func (s *FileTrustStore) save() error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(s.inner.List())
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, buf.Bytes(), 0600)
+}
@@ -0,0 +1,54 @@
+package ospc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTrustStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trust.json")
+
+	s, err := NewFileTrustStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := TrustedPeer{
+		PeerID:      PeerID("foo"),
+		DisplayName: "Agent007",
+		ModelName:   "Bond",
+		TrustedAt:   time.Now(),
+	}
+	err = s.Add(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Add(TrustedPeer{PeerID: PeerID("bar")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Remove(PeerID("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileTrustStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, ok := reloaded.Get(peer.PeerID)
+	if !ok {
+		t.Fatalf("trusted peer not persisted")
+	}
+	if actual.DisplayName != peer.DisplayName {
+		t.Fatalf("wrong DisplayName: %s != %s", actual.DisplayName, peer.DisplayName)
+	}
+	if _, ok := reloaded.Get(PeerID("bar")); ok {
+		t.Fatalf("revoked peer persisted")
+	}
+	if len(reloaded.List()) != 1 {
+		t.Fatalf("wrong number of trusted peers: %d", len(reloaded.List()))
+	}
+}
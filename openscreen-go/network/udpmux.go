@@ -0,0 +1,305 @@
+package ospc
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// udpMuxMaxPacketSize is the largest UDP payload that is read.
+	udpMuxMaxPacketSize = 1 << 16
+	// udpMuxReceiveBacklog is the number of packets queued per remote
+	// address before further packets are dropped.
+	udpMuxReceiveBacklog = 256
+	// udpMuxAcceptBacklog is the number of new remote addresses queued
+	// before their packets are dropped.
+	udpMuxAcceptBacklog = 16
+	// udpMuxMaxPending is the number of remote addresses that haven't
+	// completed a handshake yet. Packets of further new remote addresses
+	// are dropped, so spoofed source addresses can't exhaust the listener.
+	udpMuxMaxPending = 64
+)
+
+// udpMux demultiplexes a UDP socket by remote address. OSP uses
+// zero-length connection IDs, so quic-go can't tell multiple connections
+// on one socket apart. Instead, each remote address gets a virtual socket
+// that can be served by its own quic.Transport.
+type udpMux struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	conns   map[string]*udpMuxConn
+	pending int // Virtual sockets without a handshake
+	accept  chan *udpMuxConn
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func newUDPMux(conn net.PacketConn) *udpMux {
+	m := &udpMux{
+		conn:   conn,
+		conns:  make(map[string]*udpMuxConn),
+		accept: make(chan *udpMuxConn, udpMuxAcceptBacklog),
+		close:  make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *udpMux) run() {
+	buf := make([]byte, udpMuxMaxPacketSize)
+	for {
+		n, addr, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			_ = m.Close()
+			return
+		}
+
+		c := m.getConn(addr)
+		if c == nil {
+			continue
+		}
+		c.deliver(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// getConn returns the virtual socket for a remote address. A new one is
+// created and queued for Accept if needed. It returns nil if the accept
+// queue is full or too many remote addresses are pending.
+func (m *udpMux) getConn(addr net.Addr) *udpMuxConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.conns[addr.String()]
+	if ok {
+		return c
+	}
+
+	if m.pending >= udpMuxMaxPending {
+		return nil
+	}
+
+	c = newUDPMuxConn(m, addr)
+	select {
+	case m.accept <- c:
+	default:
+		return nil
+	}
+	m.conns[addr.String()] = c
+	m.pending++
+	return c
+}
+
+// established marks the handshake of a virtual socket as completed.
+func (m *udpMux) established(c *udpMuxConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c.pending {
+		c.pending = false
+		m.pending--
+	}
+}
+
+func (m *udpMux) removeConn(c *udpMuxConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c.pending {
+		c.pending = false
+		m.pending--
+	}
+	if m.conns[c.remote.String()] == c {
+		delete(m.conns, c.remote.String())
+	}
+}
+
+// Accept returns the virtual socket of the next new remote address.
+func (m *udpMux) Accept() (*udpMuxConn, error) {
+	select {
+	case <-m.close:
+		return nil, net.ErrClosed
+	case c := <-m.accept:
+		return c, nil
+	}
+}
+
+func (m *udpMux) LocalAddr() net.Addr {
+	return m.conn.LocalAddr()
+}
+
+// Close closes the socket and all virtual sockets.
+func (m *udpMux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.close)
+		err = m.conn.Close()
+
+		m.mu.Lock()
+		conns := m.conns
+		m.conns = make(map[string]*udpMuxConn)
+		m.mu.Unlock()
+
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	})
+	return err
+}
+
+var _ net.PacketConn = &udpMuxConn{}
+
+// udpMuxConn is a virtual socket for the packets of one remote address.
+type udpMuxConn struct {
+	mux     *udpMux
+	remote  net.Addr
+	packets chan []byte
+	pending bool // Protected by the lock of the mux
+
+	mu              sync.Mutex
+	deadline        time.Time
+	deadlineTimer   *time.Timer
+	deadlineChanged chan struct{}
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+func newUDPMuxConn(m *udpMux, remote net.Addr) *udpMuxConn {
+	return &udpMuxConn{
+		mux:             m,
+		remote:          remote,
+		packets:         make(chan []byte, udpMuxReceiveBacklog),
+		pending:         true,
+		deadlineChanged: make(chan struct{}),
+		close:           make(chan struct{}),
+	}
+}
+
+// deliver queues a packet. Like on a network, the packet is dropped if
+// the reader doesn't keep up.
+func (c *udpMuxConn) deliver(p []byte) {
+	select {
+	case c.packets <- p:
+	default:
+	}
+}
+
+func (c *udpMuxConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		expired := !c.deadline.IsZero() && !time.Now().Before(c.deadline)
+		changed := c.deadlineChanged
+		c.mu.Unlock()
+		if expired {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+
+		select {
+		case <-c.close:
+			return 0, nil, net.ErrClosed
+		case <-changed:
+		case packet := <-c.packets:
+			return copy(p, packet), c.remote, nil
+		}
+	}
+}
+
+func (c *udpMuxConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.close:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.mux.conn.WriteTo(p, addr)
+}
+
+func (c *udpMuxConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.close)
+		c.mux.removeConn(c)
+	})
+	return nil
+}
+
+// LocalAddr returns a unique address per remote address since quic-go
+// doesn't allow multiple transports on the same local address.
+func (c *udpMuxConn) LocalAddr() net.Addr {
+	return &udpMuxAddr{
+		local:  c.mux.LocalAddr(),
+		remote: c.remote,
+	}
+}
+
+func (c *udpMuxConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *udpMuxConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	if c.deadlineTimer != nil {
+		c.deadlineTimer.Stop()
+		c.deadlineTimer = nil
+	}
+	c.notifyDeadlineChanged()
+
+	if !t.IsZero() {
+		c.deadlineTimer = time.AfterFunc(time.Until(t), func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			c.notifyDeadlineChanged()
+		})
+	}
+	return nil
+}
+
+// Caller must hold the lock.
+func (c *udpMuxConn) notifyDeadlineChanged() {
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+}
+
+// SetWriteDeadline is a no-op, writes to a UDP socket don't block.
+func (c *udpMuxConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadBuffer sets the receive buffer of the shared socket.
+func (c *udpMuxConn) SetReadBuffer(bytes int) error {
+	conn, ok := c.mux.conn.(interface{ SetReadBuffer(int) error })
+	if !ok {
+		return errors.New("socket doesn't allow setting the receive buffer size")
+	}
+	return conn.SetReadBuffer(bytes)
+}
+
+// SetWriteBuffer sets the send buffer of the shared socket.
+func (c *udpMuxConn) SetWriteBuffer(bytes int) error {
+	conn, ok := c.mux.conn.(interface{ SetWriteBuffer(int) error })
+	if !ok {
+		return errors.New("socket doesn't allow setting the send buffer size")
+	}
+	return conn.SetWriteBuffer(bytes)
+}
+
+// udpMuxAddr is the local address of a udpMuxConn.
+type udpMuxAddr struct {
+	local  net.Addr
+	remote net.Addr
+}
+
+func (a *udpMuxAddr) Network() string {
+	return a.local.Network()
+}
+
+func (a *udpMuxAddr) String() string {
+	return a.local.String() + "/" + a.remote.String()
+}
@@ -0,0 +1,63 @@
+package ospc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUDPMuxMaxPending(t *testing.T) {
+	conn, err := listenUDP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newUDPMux(conn)
+	defer m.Close()
+
+	// send sends a datagram from a new remote address and returns the
+	// virtual socket it was accepted on within timeout, if any.
+	send := func(timeout time.Duration) *udpMuxConn {
+		t.Helper()
+
+		c, err := listenUDP("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		_, err = c.WriteTo([]byte("ping"), m.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case mc := <-m.accept:
+			return mc
+		case <-time.After(timeout):
+			return nil
+		}
+	}
+
+	var conns []*udpMuxConn
+	for i := 0; i < udpMuxMaxPending; i++ {
+		mc := send(5 * time.Second)
+		if mc == nil {
+			t.Fatalf("remote address %d dropped", i)
+		}
+		conns = append(conns, mc)
+	}
+
+	// Datagrams of new remote addresses are dropped once the limit is
+	// reached.
+	if mc := send(200 * time.Millisecond); mc != nil {
+		t.Fatalf("unexpected remote address: %s", mc.remote)
+	}
+
+	// Completed handshakes and closed sockets free up the limit.
+	m.established(conns[0])
+	if mc := send(5 * time.Second); mc == nil {
+		t.Fatal("remote address dropped after handshake")
+	}
+	_ = conns[1].Close()
+	if mc := send(5 * time.Second); mc == nil {
+		t.Fatal("remote address dropped after close")
+	}
+}
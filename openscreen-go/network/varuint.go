@@ -63,6 +63,21 @@ func writeVaruint(v uint64, w io.Writer) error {
 	}
 	return errors.New("varint: value to big")
 }
+
+// varuintLen returns the encoded length of v.
+func varuintLen(v uint64) int {
+	switch {
+	case v <= maxInt6:
+		return 1
+	case v <= maxInt14:
+		return 2
+	case v <= maxInt30:
+		return 4
+	default:
+		return 8
+	}
+}
+
 func first2Bits(b byte) byte {
 	return b >> 6
 }
@@ -29,32 +29,76 @@ func (a *ListenerAdaptor[S]) Close() error {
 	return a.inner.Close()
 }
 
-type ConcreteSession[S Stream] interface {
+type ConcreteSession[S Stream, R ReceiveStream, W SendStream] interface {
 	AcceptStream(context.Context) (S, error)
+	AcceptUniStream(context.Context) (R, error)
 	OpenStreamSync(context.Context) (S, error)
+	OpenUniStreamSync(context.Context) (W, error)
+	SendDatagram([]byte) error
+	ReceiveDatagram(context.Context) ([]byte, error)
+	MaxDatagramSize() int
 	CloseWithError(uint64, string) error
+	Context() context.Context
 }
 
 // SessionAdaptor is a shim to convert concrete implementation
 // to a generic one.
-type SessionAdaptor[S Stream] struct {
-	inner ConcreteSession[S]
+type SessionAdaptor[S Stream, R ReceiveStream, W SendStream] struct {
+	inner ConcreteSession[S, R, W]
+	stats StatsFunc
 }
 
-func NewSessionAdaptor[S Stream](s ConcreteSession[S]) *SessionAdaptor[S] {
-	return &SessionAdaptor[S]{
+func NewSessionAdaptor[S Stream, R ReceiveStream, W SendStream](s ConcreteSession[S, R, W]) *SessionAdaptor[S, R, W] {
+	return &SessionAdaptor[S, R, W]{
 		inner: s,
 	}
 }
 
-func (a *SessionAdaptor[S]) AcceptStream(ctx context.Context) (Stream, error) {
+func (a *SessionAdaptor[S, R, W]) AcceptStream(ctx context.Context) (Stream, error) {
 	return a.inner.AcceptStream(ctx)
 }
 
-func (a *SessionAdaptor[S]) OpenStreamSync(ctx context.Context) (Stream, error) {
+func (a *SessionAdaptor[S, R, W]) AcceptUniStream(ctx context.Context) (ReceiveStream, error) {
+	return a.inner.AcceptUniStream(ctx)
+}
+
+func (a *SessionAdaptor[S, R, W]) OpenStreamSync(ctx context.Context) (Stream, error) {
 	return a.inner.OpenStreamSync(ctx)
 }
 
-func (a *SessionAdaptor[S]) CloseWithError(i uint64, msg string) error {
+func (a *SessionAdaptor[S, R, W]) OpenUniStreamSync(ctx context.Context) (SendStream, error) {
+	return a.inner.OpenUniStreamSync(ctx)
+}
+
+func (a *SessionAdaptor[S, R, W]) SendDatagram(p []byte) error {
+	return a.inner.SendDatagram(p)
+}
+
+func (a *SessionAdaptor[S, R, W]) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return a.inner.ReceiveDatagram(ctx)
+}
+
+func (a *SessionAdaptor[S, R, W]) MaxDatagramSize() int {
+	return a.inner.MaxDatagramSize()
+}
+
+// WithStats sets the function that reports the statistics of the
+// session, since those are specific to the implementation.
+func (a *SessionAdaptor[S, R, W]) WithStats(f StatsFunc) {
+	a.stats = f
+}
+
+func (a *SessionAdaptor[S, R, W]) GetStats() (WebTransportConnectionStats, error) {
+	if a.stats == nil {
+		return WebTransportConnectionStats{}, ErrStatsUnsupported
+	}
+	return a.stats()
+}
+
+func (a *SessionAdaptor[S, R, W]) CloseWithError(i uint64, msg string) error {
 	return a.inner.CloseWithError(i, msg)
 }
+
+func (a *SessionAdaptor[S, R, W]) Context() context.Context {
+	return a.inner.Context()
+}
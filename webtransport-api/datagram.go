@@ -0,0 +1,59 @@
+package webtransport
+
+import (
+	"context"
+
+	"github.com/backkem/go-lp2p/streams-api"
+)
+
+// WebTransportDatagramDuplexStream sends and receives unreliable,
+// unordered datagrams on a session.
+type WebTransportDatagramDuplexStream struct {
+	Readable streams.ReadableStream[[]byte]
+	Writable streams.WritableStream[[]byte]
+
+	session Session
+}
+
+func NewWebTransportDatagramDuplexStream(s Session) *WebTransportDatagramDuplexStream {
+	return &WebTransportDatagramDuplexStream{
+		Readable: streams.NewValueReadableStream(&datagramReader{
+			inner: s,
+		}),
+		Writable: streams.NewValueWritableStream(&datagramWriter{
+			inner: s,
+		}),
+		session: s,
+	}
+}
+
+// MaxDatagramSize returns the largest datagram that can be written, or 0
+// if the session doesn't support datagrams.
+func (d *WebTransportDatagramDuplexStream) MaxDatagramSize() int {
+	return d.session.MaxDatagramSize()
+}
+
+type datagramReader struct {
+	inner Session
+}
+
+var _ streams.ValueReader[[]byte] = (*datagramReader)(nil)
+
+func (r *datagramReader) Read() ([]byte, error) {
+	return r.inner.ReceiveDatagram(context.Background())
+}
+
+type datagramWriter struct {
+	inner Session
+}
+
+var _ streams.ValueWriteCloser[[]byte] = (*datagramWriter)(nil)
+
+func (w *datagramWriter) Write(p []byte) error {
+	return w.inner.SendDatagram(p)
+}
+
+// Close is a no-op, datagrams end with the session.
+func (w *datagramWriter) Close() error {
+	return nil
+}
@@ -17,10 +17,26 @@ type Listener interface {
 // A Session
 type Session interface {
 	AcceptStream(context.Context) (Stream, error)
-	// AcceptUniStream(context.Context) (ReceiveStream, error)
+	AcceptUniStream(context.Context) (ReceiveStream, error)
 	OpenStreamSync(context.Context) (Stream, error)
-	// OpenUniStreamSync(context.Context) (SendStream, error)
+	OpenUniStreamSync(context.Context) (SendStream, error)
+	SendDatagram([]byte) error
+	ReceiveDatagram(context.Context) ([]byte, error)
+	// MaxDatagramSize is 0 if datagrams aren't supported.
+	MaxDatagramSize() int
+	// GetStats reports the statistics of the underlying connection.
+	GetStats() (WebTransportConnectionStats, error)
 	CloseWithError(uint64, string) error
+	// Context is cancelled when the session is closed. If it was closed
+	// by either end, the cause implements CloseError.
+	Context() context.Context
+}
+
+// CloseError is the cause of a session that was closed by either end.
+type CloseError interface {
+	error
+	CloseCode() uint64
+	CloseReason() string
 }
 
 // Stream
@@ -0,0 +1,26 @@
+package webtransport
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrStatsUnsupported = errors.New("stats not supported")
+
+// WebTransportConnectionStats are the statistics of the connection
+// underlying a session.
+type WebTransportConnectionStats struct {
+	BytesSent        uint64
+	PacketsSent      uint64
+	BytesReceived    uint64
+	PacketsReceived  uint64
+	PacketsLost      uint64
+	SmoothedRTT      time.Duration
+	RTTVariation     time.Duration
+	MinRTT           time.Duration
+	CongestionWindow uint64
+}
+
+// StatsFunc reports the statistics of a session, see
+// SessionAdaptor.WithStats.
+type StatsFunc func() (WebTransportConnectionStats, error)
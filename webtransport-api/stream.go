@@ -1,7 +1,7 @@
 package webtransport
 
 import (
-	"errors"
+	"sync/atomic"
 
 	"github.com/backkem/go-lp2p/streams-api"
 )
@@ -20,39 +20,55 @@ func NewWebTransportBidirectionalStream(s Stream) WebTransportBidirectionalStrea
 
 type WebTransportReceiveStream struct {
 	streams.ReadableStream[[]byte]
-	inner Stream
+	inner *countingReceiveStream
 }
 
-func NewWebTransportReceiveStream(s Stream) WebTransportReceiveStream {
+func NewWebTransportReceiveStream(s ReceiveStream) WebTransportReceiveStream {
+	inner := &countingReceiveStream{ReceiveStream: s}
 	return WebTransportReceiveStream{
-		ReadableStream: streams.NewDataReadableStream(s),
-		inner:          s,
+		ReadableStream: streams.NewDataReadableStream(inner),
+		inner:          inner,
 	}
 }
 
+// WebTransportReceiveStreamStats are the byte counters of a receive
+// stream. Data is counted as received once it's read from the
+// underlying stream, so BytesReceived equals BytesRead.
 type WebTransportReceiveStreamStats struct {
 	BytesReceived uint64
 	BytesRead     uint64
 }
 
 func (s WebTransportReceiveStream) GetStats() (WebTransportReceiveStreamStats, error) {
-	return WebTransportReceiveStreamStats{}, errors.New("unimplemented")
+	read := s.inner.read.Load()
+	return WebTransportReceiveStreamStats{
+		BytesReceived: read,
+		BytesRead:     read,
+	}, nil
 }
 
 type WebTransportSendStream struct {
 	streams.WritableStream[[]byte]
-	inner Stream
+	inner *countingSendStream
 }
 
-func NewWebTransportSendStream(s Stream) WebTransportSendStream {
+func NewWebTransportSendStream(s SendStream) WebTransportSendStream {
+	inner := &countingSendStream{SendStream: s}
 	return WebTransportSendStream{
-		WritableStream: streams.NewDataWritableStream(s),
-		inner:          s,
+		WritableStream: streams.NewDataWritableStream(inner),
+		inner:          inner,
 	}
 }
 
+// GetStats returns the byte counters of the stream. Data is counted as
+// sent once the underlying stream accepted it. Acknowledgements aren't
+// exposed by the underlying streams, BytesAcknowledged stays 0.
 func (s WebTransportSendStream) GetStats() (WebTransportSendStreamStats, error) {
-	return WebTransportSendStreamStats{}, errors.New("unimplemented")
+	written := s.inner.written.Load()
+	return WebTransportSendStreamStats{
+		BytesWritten: written,
+		BytesSent:    written,
+	}, nil
 }
 
 type WebTransportSendStreamStats struct {
@@ -60,3 +76,27 @@ type WebTransportSendStreamStats struct {
 	BytesSent         uint64
 	BytesAcknowledged uint64
 }
+
+// countingReceiveStream counts the bytes read from a stream.
+type countingReceiveStream struct {
+	ReceiveStream
+	read atomic.Uint64
+}
+
+func (s *countingReceiveStream) Read(p []byte) (int, error) {
+	n, err := s.ReceiveStream.Read(p)
+	s.read.Add(uint64(n))
+	return n, err
+}
+
+// countingSendStream counts the bytes written to a stream.
+type countingSendStream struct {
+	SendStream
+	written atomic.Uint64
+}
+
+func (s *countingSendStream) Write(p []byte) (int, error) {
+	n, err := s.SendStream.Write(p)
+	s.written.Add(uint64(n))
+	return n, err
+}
@@ -2,6 +2,7 @@ package webtransport
 
 import (
 	"context"
+	"errors"
 	"sync"
 
 	"github.com/backkem/go-lp2p/streams-api"
@@ -14,20 +15,25 @@ type Transport struct {
 	lock    sync.RWMutex
 	session Session
 
-	IncomingBidirectionalStreams streams.ReadableStream[WebTransportBidirectionalStream]
-	// IncomingUnidirectionalStreams streams.IterReadableStream[streams.DataReadableStream]
-
+	IncomingBidirectionalStreams  streams.ReadableStream[WebTransportBidirectionalStream]
+	IncomingUnidirectionalStreams streams.ReadableStream[WebTransportReceiveStream]
+	Datagrams                     *WebTransportDatagramDuplexStream
 }
 
 func NewTransport(s Session) (*Transport, error) {
 	inBi := streams.NewValueReadableStream(&streamReader{
 		inner: s,
 	})
+	inUni := streams.NewValueReadableStream(&uniStreamReader{
+		inner: s,
+	})
 
 	base := &Transport{
-		lock:                         sync.RWMutex{},
-		session:                      s,
-		IncomingBidirectionalStreams: inBi,
+		lock:                          sync.RWMutex{},
+		session:                       s,
+		IncomingBidirectionalStreams:  inBi,
+		IncomingUnidirectionalStreams: inUni,
+		Datagrams:                     NewWebTransportDatagramDuplexStream(s),
 	}
 
 	return base, nil
@@ -43,8 +49,15 @@ func (b *Transport) CreateBidirectionalStream() (WebTransportBidirectionalStream
 	return NewWebTransportBidirectionalStream(s), nil
 }
 
-// func (b *TransportBase) CreateUnidirectionalStream() (*WritableStream, error) {
-// }
+// CreateUnidirectionalStream creates a send-only stream.
+func (b *Transport) CreateUnidirectionalStream() (WebTransportSendStream, error) {
+	s, err := b.session.OpenUniStreamSync(context.Background())
+	if err != nil {
+		return WebTransportSendStream{}, err
+	}
+
+	return NewWebTransportSendStream(s), nil
+}
 
 type streamReader struct {
 	inner Session
@@ -61,6 +74,21 @@ func (i *streamReader) Read() (WebTransportBidirectionalStream, error) {
 	return NewWebTransportBidirectionalStream(s), nil
 }
 
+type uniStreamReader struct {
+	inner Session
+}
+
+var _ streams.ValueReader[WebTransportReceiveStream] = (*uniStreamReader)(nil)
+
+func (i *uniStreamReader) Read() (WebTransportReceiveStream, error) {
+	s, err := i.inner.AcceptUniStream(context.Background())
+	if err != nil {
+		return WebTransportReceiveStream{}, err
+	}
+
+	return NewWebTransportReceiveStream(s), nil
+}
+
 // Close the TransportBase.
 func (b *Transport) Close(closeInfo WebTransportCloseInfo) error {
 	b.lock.Lock()
@@ -78,6 +106,40 @@ func (b *Transport) Close(closeInfo WebTransportCloseInfo) error {
 	return b.session.CloseWithError(0, "close")
 }
 
+// GetStats reports the statistics of the underlying connection. For a
+// pooled transport, they include the traffic of the other sessions.
+func (b *Transport) GetStats() (WebTransportConnectionStats, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.session.GetStats()
+}
+
+// Closed blocks until the transport is closed. If it was closed by either
+// end, the close info is returned. Otherwise, the error that aborted the
+// transport is returned.
+func (b *Transport) Closed(ctx context.Context) (WebTransportCloseInfo, error) {
+	b.lock.RLock()
+	sessionCtx := b.session.Context()
+	b.lock.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return WebTransportCloseInfo{}, ctx.Err()
+	case <-sessionCtx.Done():
+	}
+
+	cause := context.Cause(sessionCtx)
+	var closeErr CloseError
+	if errors.As(cause, &closeErr) {
+		return WebTransportCloseInfo{
+			CloseCode: closeErr.CloseCode(),
+			Reason:    closeErr.CloseReason(),
+		}, nil
+	}
+	return WebTransportCloseInfo{}, cause
+}
+
 type WebTransportCloseInfo struct {
 	CloseCode uint64
 	Reason    string